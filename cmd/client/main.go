@@ -2,15 +2,36 @@ package main
 
 import (
 	"bufio"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"flag"
 	"fmt"
 	"net"
 	"os"
+	"strings"
+
+	"github.com/hashicorp/yamux"
+	"github.com/yLukas077/tcp-vote/internal/protocol"
 )
 
 func main() {
-	// SYSCALL: socket() + connect() - cria socket TCP e estabelece conexão com servidor
-    // Kernel cria um file descriptor (FD) para rastrear este socket
-	conn, err := net.Dial("tcp", "localhost:9000")
+	addr := flag.String("addr", "localhost:9000", "endereco do servidor")
+	tlsEnabled := flag.Bool("tls", false, "conecta via mTLS (exige -tls-ca, -tls-cert e -tls-key)")
+	tlsCA := flag.String("tls-ca", "", "arquivo PEM da CA que assina o certificado do servidor")
+	tlsCert := flag.String("tls-cert", "", "arquivo PEM do certificado deste cliente")
+	tlsKey := flag.String("tls-key", "", "arquivo PEM da chave privada deste cliente")
+	flag.Parse()
+
+	var conn net.Conn
+	var err error
+	if *tlsEnabled {
+		conn, err = dialMutualTLS(*addr, *tlsCA, *tlsCert, *tlsKey)
+	} else {
+		// SYSCALL: socket() + connect() - cria socket TCP e estabelece conexão com servidor
+		// Kernel cria um file descriptor (FD) para rastrear este socket
+		conn, err = net.Dial("tcp", *addr)
+	}
 	if err != nil {
 		fmt.Println("Erro ao conectar:", err)
 		return
@@ -19,19 +40,43 @@ func main() {
 
 	fmt.Println("Conectado ao servidor TCP!")
 
-	// Goroutine dedicada para leitura assíncrona
-	// Permite receber broadcasts enquanto o usuário digita
-	go func() {
-		scanner := bufio.NewScanner(conn)
-		// SYSCALL: read(fd, buffer, size) - bloqueante até dados chegarem
-		for scanner.Scan() {
-			fmt.Println("\n[SERVIDOR]:", scanner.Text())
-			fmt.Print(">> ")
-		}
-		// Servidor encerrou conexão (close do FD remoto)
-		fmt.Println("\nConexão com o servidor encerrada.")
-		os.Exit(0)
-	}()
+	// O servidor fala TransportMux (ver cmd/server/main.go): a conexão TCP
+	// carrega uma sessão yamux com três streams lógicos, abertos nesta mesma
+	// ordem do outro lado em Server.openServerStreams - controle, placar,
+	// eventos. Um placar com a janela cheia não trava mais os comandos do
+	// usuário, que seguem no stream de controle.
+	session, err := yamux.Client(conn, nil)
+	if err != nil {
+		fmt.Println("Erro ao abrir sessao yamux:", err)
+		return
+	}
+	defer session.Close()
+
+	control, err := session.Open()
+	if err != nil {
+		fmt.Println("Erro ao abrir stream de controle:", err)
+		return
+	}
+	broadcast, err := session.Open()
+	if err != nil {
+		fmt.Println("Erro ao abrir stream de placar:", err)
+		return
+	}
+	event, err := session.Open()
+	if err != nil {
+		fmt.Println("Erro ao abrir stream de eventos:", err)
+		return
+	}
+
+	enc := protocol.NewEncoder(control)
+	dec := protocol.NewDecoder(control)
+
+	// Cada stream lógico tem sua própria goroutine de leitura: eles já
+	// chegam com controle de fluxo isolado via yamux, então um consumidor
+	// lento de um stream não atrasa a leitura dos outros dois.
+	go readLoop(dec)
+	go readLoop(protocol.NewDecoder(broadcast))
+	go readLoop(protocol.NewDecoder(event))
 
 	scanner := bufio.NewScanner(os.Stdin)
 
@@ -39,17 +84,139 @@ func main() {
 	fmt.Print("Digite seu NOME para entrar: ")
 	scanner.Scan()
 	id := scanner.Text()
-	
+
 	// SYSCALL: write(fd, buffer, len) - escreve no socket TCP usando seu FD
-	fmt.Fprintf(conn, "%s\n", id)
+	if err := enc.Encode(protocol.TypeHello, protocol.HelloMsg{ID: id}); err != nil {
+		fmt.Println("Erro ao enviar HELLO:", err)
+		return
+	}
 
 	// Loop de envio de comandos
+	fmt.Println("Comandos: LIST | CREATE <pollID> <opt1,opt2,...> | JOIN <pollID> | LEAVE <pollID> | VOTE <pollID> <opcao>")
 	for {
 		fmt.Print(">> ")
 		if !scanner.Scan() {
 			break
 		}
-		text := scanner.Text()
-		fmt.Fprintf(conn, "%s\n", text)
+		if err := sendCommand(enc, scanner.Text()); err != nil {
+			fmt.Println("Erro ao enviar comando:", err)
+		}
+	}
+}
+
+// dialMutualTLS conecta a addr autenticando este cliente com o certificado
+// certFile/keyFile e validando o certificado do servidor contra a CA em
+// caFile - o par mTLS de server.LoadMutualTLS, do lado do cliente.
+func dialMutualTLS(addr, caFile, certFile, keyFile string) (net.Conn, error) {
+	cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+	if err != nil {
+		return nil, fmt.Errorf("erro ao carregar certificado do cliente: %w", err)
+	}
+
+	caPEM, err := os.ReadFile(caFile)
+	if err != nil {
+		return nil, fmt.Errorf("erro ao ler CA do servidor %s: %w", caFile, err)
+	}
+	caPool := x509.NewCertPool()
+	if !caPool.AppendCertsFromPEM(caPEM) {
+		return nil, fmt.Errorf("CA do servidor %s nao contem nenhum certificado PEM valido", caFile)
+	}
+
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		host = addr
+	}
+	return tls.Dial("tcp", addr, &tls.Config{
+		Certificates: []tls.Certificate{cert},
+		RootCAs:      caPool,
+		ServerName:   host,
+	})
+}
+
+// readLoop consome frames de um stream (controle, placar ou eventos) até a
+// conexão cair, imprimindo cada um no terminal. Permite receber broadcasts e
+// eventos enquanto o usuário digita, sem bloquear nenhum dos outros streams.
+func readLoop(dec *protocol.Decoder) {
+	for {
+		// SYSCALL: read(fd, buffer, size) - bloqueante até dados chegarem
+		msgType, data, err := dec.Decode()
+		if err != nil {
+			fmt.Println("\nConexão com o servidor encerrada.")
+			os.Exit(0)
+		}
+		fmt.Printf("\n[SERVIDOR] %s\n", describe(msgType, data))
+		fmt.Print(">> ")
+	}
+}
+
+// sendCommand traduz uma linha digitada pelo usuário na mensagem tipada
+// correspondente do protocolo.
+func sendCommand(enc *protocol.Encoder, line string) error {
+	line = strings.TrimSpace(line)
+	switch {
+	case line == "LIST":
+		return enc.Encode(protocol.TypeList, protocol.ListMsg{})
+	case strings.HasPrefix(line, "CREATE "):
+		parts := strings.SplitN(strings.TrimPrefix(line, "CREATE "), " ", 2)
+		if len(parts) != 2 {
+			return fmt.Errorf("uso: CREATE <pollID> <opt1,opt2,...>")
+		}
+		return enc.Encode(protocol.TypeCreate, protocol.CreateMsg{
+			PollID:  parts[0],
+			Options: strings.Split(parts[1], ","),
+		})
+	case strings.HasPrefix(line, "JOIN "):
+		return enc.Encode(protocol.TypeJoin, protocol.JoinMsg{PollID: strings.TrimPrefix(line, "JOIN ")})
+	case strings.HasPrefix(line, "LEAVE "):
+		return enc.Encode(protocol.TypeLeave, protocol.LeaveMsg{PollID: strings.TrimPrefix(line, "LEAVE ")})
+	case strings.HasPrefix(line, "VOTE "):
+		parts := strings.SplitN(strings.TrimPrefix(line, "VOTE "), " ", 2)
+		if len(parts) != 2 {
+			return fmt.Errorf("uso: VOTE <pollID> <opcao>")
+		}
+		return enc.Encode(protocol.TypeVote, protocol.VoteMsg{PollID: parts[0], Option: parts[1]})
+	default:
+		return fmt.Errorf("comando desconhecido: %s", line)
+	}
+}
+
+// describe formata um frame recebido do servidor para exibição no terminal.
+func describe(msgType protocol.MessageType, data json.RawMessage) string {
+	switch msgType {
+	case protocol.TypeWelcome:
+		var m protocol.WelcomeMsg
+		json.Unmarshal(data, &m)
+		return m.Message
+	case protocol.TypeOk:
+		var m protocol.OkMsg
+		json.Unmarshal(data, &m)
+		return "OK: " + m.Message
+	case protocol.TypeError:
+		var m protocol.ErrorMsg
+		json.Unmarshal(data, &m)
+		return fmt.Sprintf("ERRO [%s]: %s", m.Code, m.Message)
+	case protocol.TypePollList:
+		var m protocol.PollListMsg
+		json.Unmarshal(data, &m)
+		var sb strings.Builder
+		sb.WriteString("POLLS:")
+		for _, p := range m.Polls {
+			fmt.Fprintf(&sb, " %s(%s)", p.ID, p.State)
+		}
+		return sb.String()
+	case protocol.TypeUpdate:
+		var m protocol.UpdateMsg
+		json.Unmarshal(data, &m)
+		return fmt.Sprintf("UPDATE %s (seq=%d): %v", m.PollID, m.Seq, m.Counts)
+	case protocol.TypePollEvent:
+		var m protocol.PollEventMsg
+		json.Unmarshal(data, &m)
+		return fmt.Sprintf("%s %s: %s", m.Event, m.PollID, m.Message)
+	case protocol.TypeShutdown:
+		var m protocol.ShutdownMsg
+		json.Unmarshal(data, &m)
+		return m.Message
+	default:
+		return fmt.Sprintf("%s %s", msgType, string(data))
 	}
 }
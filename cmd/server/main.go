@@ -1,15 +1,25 @@
 package main
 
 import (
+	"context"
+	"flag"
 	"fmt"
 	"log"
 	"os"
+	"os/signal"
+	"syscall"
 	"time"
 
 	"github.com/yLukas077/tcp-vote/internal/server"
 )
 
 func main() {
+	tlsEnabled := flag.Bool("tls", false, "habilita mTLS (exige -tls-ca, -tls-cert e -tls-key)")
+	tlsCA := flag.String("tls-ca", "", "arquivo PEM da CA que assina os certificados de cliente")
+	tlsCert := flag.String("tls-cert", "", "arquivo PEM do certificado do servidor")
+	tlsKey := flag.String("tls-key", "", "arquivo PEM da chave privada do servidor")
+	flag.Parse()
+
 	// Redireciona logs para arquivo persistente
 	logFile, err := os.OpenFile("logs/server.log", os.O_CREATE|os.O_APPEND|os.O_RDWR, 0666)
 	if err != nil {
@@ -20,19 +30,60 @@ func main() {
 
 	fmt.Println("=== SERVIDOR TCP DE VOTAÇÃO ===")
 	fmt.Println("Logs: logs/server.log")
-	fmt.Println("Modo: Assíncrono (Chanells + Worker)")
+	fmt.Println("Modo: Fanout (fila por cliente + goroutine de envio dedicada)")
+	fmt.Println("Transporte: Mux (streams yamux de controle/placar/eventos por cliente)")
+
+	// server.ModeSync  = bloqueante (trava poll.mu durante conn.Write, propósito didático)
+	// server.ModeAsync = channel + worker (ainda escreve direto, mas fora da seção crítica do poll)
+	// server.ModeFanout = fila por cliente/poll, nenhum broadcast bloqueia mutex em I/O
+	srv := server.NewServer(server.ModeFanout)
+
+	// server.TransportRaw = um socket só para controle, placar e eventos (padrão)
+	// server.TransportMux = sessão yamux com um stream por papel, sem bloqueio cruzado
+	srv.Transport = server.TransportMux
+
+	if *tlsEnabled {
+		tlsConfig, err := server.LoadMutualTLS(*tlsCA, *tlsCert, *tlsKey)
+		if err != nil {
+			log.Fatalf("Erro ao carregar configuracao TLS: %v", err)
+		}
+		srv.TLSConfig = tlsConfig
+		fmt.Println("TLS: habilitado (mTLS)")
+	}
+
+	// Poll padrão, aberto a todo cliente que der JOIN default
+	if err := srv.CreatePoll("default", []string{"A", "B", "C"}); err != nil {
+		log.Fatalf("Erro ao criar poll padrão: %v", err)
+	}
 
-	// Opções de voto configuráveis
-	opcoes := []string{"A", "B", "C"}
-	// Inicia servidor em modo assíncrono (true = non-blocking broadcast)
-	srv := server.NewServer(true, opcoes)
+	// Sidecar de observabilidade: /debug/vars, /debug/pprof/* e /healthz
+	metricsAddr, err := srv.StartMetrics("127.0.0.1:6060")
+	if err != nil {
+		log.Printf("Aviso: sidecar de métricas não subiu: %v", err)
+	} else {
+		fmt.Printf("Métricas: http://%s/debug/vars\n", metricsAddr)
+	}
 
-	// Inicia votação após 5 segundos com duração de 60 segundos
+	// Inicia votação do poll padrão após 5 segundos com duração de 300 segundos
 	go func() {
 		time.Sleep(5 * time.Second)
-		fmt.Println("Iniciando votação (300 segundos)...")
-		srv.StartVoting(300)
+		fmt.Println("Iniciando votação do poll 'default' (300 segundos)...")
+		srv.StartVoting("default", 300)
 	}()
 
-	srv.Start(":9000")
+	go srv.Start(":9000")
+
+	// SIGINT/SIGTERM disparam um desligamento gracioso em vez de matar o
+	// processo no meio de um write, o que podia derrubar acks e truncar
+	// server.log.
+	sigCh := make(chan os.Signal, 1)
+	signal.Notify(sigCh, syscall.SIGINT, syscall.SIGTERM)
+	<-sigCh
+
+	fmt.Println("Sinal recebido, encerrando servidor...")
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	if err := srv.Shutdown(ctx); err != nil {
+		log.Printf("Shutdown não concluído dentro do prazo: %v", err)
+	}
 }
@@ -0,0 +1,175 @@
+// Package cluster replica o log de votos de um poll entre vários nós via
+// Raft (hashicorp/raft), para que um voto confirmado ao cliente sobreviva à
+// queda do líder. internal/server delega a mutação de votes/voteCounts/
+// state/deadline a este pacote em vez de guardá-los só localmente; a leitura
+// continua livre em qualquer nó, mas só o líder aceita novas mutações.
+package cluster
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/hashicorp/raft"
+	boltdb "github.com/hashicorp/raft-boltdb"
+)
+
+// applyTimeout limita quanto tempo Cluster.Apply espera um Command ser
+// commitado e aplicado antes de desistir.
+const applyTimeout = 5 * time.Second
+
+// ErrNotLeader é devolvido por Cluster.Apply quando este nó não é o líder
+// atual do cluster - o chamador (internal/server) traduz isso num
+// ErrorMsg{Code: "NAO_LIDER"} apontando LeaderAddr.
+var ErrNotLeader = errors.New("cluster: este no nao e o lider atual")
+
+// ClusterConfig descreve a participação de um nó num cluster de votação
+// replicado.
+type ClusterConfig struct {
+	// NodeID identifica este nó de forma única e estável entre reinícios
+	// (vira raft.ServerID).
+	NodeID string
+	// BindAddr é o endereço TCP usado pelo transporte Raft deste nó para
+	// AppendEntries/RequestVote com os demais peers.
+	BindAddr string
+	// Peers lista os demais nós da configuração inicial do cluster, no
+	// formato "NodeID@host:porta". Usado só no bootstrap (primeira subida,
+	// sem estado em DataDir); reconfiguração depois disso é feita via
+	// Cluster.AddVoter.
+	Peers []string
+	// DataDir é o diretório onde o log do Raft, o stable store (BoltDB) e
+	// os snapshots deste nó são persistidos.
+	DataDir string
+}
+
+// Cluster embrulha um nó Raft e a FSM replicada que ele dirige.
+type Cluster struct {
+	cfg  ClusterConfig
+	raft *raft.Raft
+	fsm  *FSM
+}
+
+// NewCluster sobe o nó Raft deste processo a partir de cfg, recuperando o
+// estado de DataDir se ele já existir. Na primeira subida (DataDir vazio),
+// inicializa a configuração do cluster com este nó e os Peers informados;
+// em reinícios subsequentes, raft.BootstrapCluster é um no-op seguro
+// (retorna raft.ErrCantBootstrap, que este construtor ignora).
+func NewCluster(cfg ClusterConfig) (*Cluster, error) {
+	if err := os.MkdirAll(cfg.DataDir, 0o755); err != nil {
+		return nil, fmt.Errorf("cluster: erro ao criar DataDir %s: %w", cfg.DataDir, err)
+	}
+
+	fsm := NewFSM()
+
+	raftCfg := raft.DefaultConfig()
+	raftCfg.LocalID = raft.ServerID(cfg.NodeID)
+
+	addr, err := net.ResolveTCPAddr("tcp", cfg.BindAddr)
+	if err != nil {
+		return nil, fmt.Errorf("cluster: endereco invalido %s: %w", cfg.BindAddr, err)
+	}
+	transport, err := raft.NewTCPTransport(cfg.BindAddr, addr, 3, 10*time.Second, os.Stderr)
+	if err != nil {
+		return nil, fmt.Errorf("cluster: erro ao abrir transporte raft: %w", err)
+	}
+
+	snapshots, err := raft.NewFileSnapshotStore(cfg.DataDir, 2, os.Stderr)
+	if err != nil {
+		return nil, fmt.Errorf("cluster: erro ao abrir snapshot store: %w", err)
+	}
+
+	store, err := boltdb.NewBoltStore(filepath.Join(cfg.DataDir, "raft.db"))
+	if err != nil {
+		return nil, fmt.Errorf("cluster: erro ao abrir boltdb em %s: %w", cfg.DataDir, err)
+	}
+
+	r, err := raft.NewRaft(raftCfg, fsm, store, store, snapshots, transport)
+	if err != nil {
+		return nil, fmt.Errorf("cluster: erro ao iniciar raft: %w", err)
+	}
+
+	servers := []raft.Server{{ID: raft.ServerID(cfg.NodeID), Address: transport.LocalAddr()}}
+	for _, peer := range cfg.Peers {
+		id, peerAddr, err := splitPeer(peer)
+		if err != nil {
+			return nil, err
+		}
+		servers = append(servers, raft.Server{ID: raft.ServerID(id), Address: raft.ServerAddress(peerAddr)})
+	}
+	if err := r.BootstrapCluster(raft.Configuration{Servers: servers}).Error(); err != nil && err != raft.ErrCantBootstrap {
+		return nil, fmt.Errorf("cluster: erro ao inicializar configuracao do cluster: %w", err)
+	}
+
+	return &Cluster{cfg: cfg, raft: r, fsm: fsm}, nil
+}
+
+// splitPeer separa um peer "NodeID@host:porta" em seus componentes.
+func splitPeer(peer string) (id, addr string, err error) {
+	parts := strings.SplitN(peer, "@", 2)
+	if len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+		return "", "", fmt.Errorf("cluster: peer mal formado (esperado NodeID@host:porta): %s", peer)
+	}
+	return parts[0], parts[1], nil
+}
+
+// FSM expõe a máquina de estados replicada para leituras (placar, estado,
+// prazo de um poll), permitidas em qualquer nó, líder ou não.
+func (c *Cluster) FSM() *FSM {
+	return c.fsm
+}
+
+// IsLeader indica se este nó é o líder atual do cluster.
+func (c *Cluster) IsLeader() bool {
+	return c.raft.State() == raft.Leader
+}
+
+// LeaderAddr retorna o BindAddr do líder atual, ou string vazia se não
+// houver um conhecido no momento (ex.: eleição em andamento).
+func (c *Cluster) LeaderAddr() string {
+	addr, _ := c.raft.LeaderWithID()
+	return string(addr)
+}
+
+// AddVoter inclui um novo nó na configuração do cluster, replicando a
+// mudança de configuração como uma entrada especial do log do Raft. Só tem
+// efeito quando chamado no líder.
+func (c *Cluster) AddVoter(nodeID, addr string) error {
+	return c.raft.AddVoter(raft.ServerID(nodeID), raft.ServerAddress(addr), 0, 0).Error()
+}
+
+// Apply serializa cmd, propõe-o ao log do Raft e bloqueia até ele ser
+// commitado pela maioria e aplicado pela FSM deste nó (dentro de
+// applyTimeout), devolvendo o ApplyResult que FSM.Apply produziu. Deve ser
+// chamado só quando IsLeader() é true; caso contrário devolve ErrNotLeader
+// sem tocar o log.
+func (c *Cluster) Apply(cmd Command) (ApplyResult, error) {
+	cmd.ProposedAt = time.Now()
+	data, err := json.Marshal(cmd)
+	if err != nil {
+		return ApplyResult{}, fmt.Errorf("cluster: erro ao serializar comando: %w", err)
+	}
+
+	future := c.raft.Apply(data, applyTimeout)
+	if err := future.Error(); err != nil {
+		if errors.Is(err, raft.ErrNotLeader) || errors.Is(err, raft.ErrLeadershipLost) {
+			return ApplyResult{}, ErrNotLeader
+		}
+		return ApplyResult{}, fmt.Errorf("cluster: erro ao aplicar comando: %w", err)
+	}
+
+	result, ok := future.Response().(ApplyResult)
+	if !ok {
+		return ApplyResult{}, fmt.Errorf("cluster: resposta inesperada da FSM")
+	}
+	return result, result.Err
+}
+
+// Shutdown encerra o nó Raft deste processo, esperando a transição completar.
+func (c *Cluster) Shutdown() error {
+	return c.raft.Shutdown().Error()
+}
@@ -0,0 +1,263 @@
+package cluster
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"sync"
+	"time"
+
+	"github.com/hashicorp/raft"
+)
+
+// VotingState espelha server.VotingState (mesmos valores de string), mas é
+// declarado aqui para que este pacote não precise importar internal/server
+// - seria um import cycle, já que Server referencia *Cluster.
+type VotingState string
+
+const (
+	StateNotStarted VotingState = "NOT_STARTED"
+	StateActive     VotingState = "ACTIVE"
+	StateEnded      VotingState = "ENDED"
+)
+
+// CmdType identifica a mutação carregada por um Command no log do Raft.
+type CmdType string
+
+const (
+	CmdStartVoting CmdType = "START_VOTING"
+	CmdVote        CmdType = "VOTE"
+	CmdEndVoting   CmdType = "END_VOTING"
+)
+
+// Command é a unidade replicada pelo Raft: cada voto, início e fim de
+// votação vira um Command serializado em JSON e só é aplicado ao placar
+// depois de commitado pela maioria do cluster.
+type Command struct {
+	Type     CmdType `json:"type"`
+	PollID   string  `json:"poll_id"`
+	Duration int     `json:"duration,omitempty"` // CmdStartVoting
+	VoterID  string  `json:"voter_id,omitempty"` // CmdVote
+	Option   string  `json:"option,omitempty"`   // CmdVote
+
+	// ProposedAt é carimbado por Cluster.Apply no nó que propôs o comando,
+	// antes de entrar no log do Raft - nunca por FSM.Apply. FSM.Apply roda
+	// em todo nó (líder ou seguidor, na hora do log ou horas depois ao
+	// repetir um snapshot) e precisa ser uma função pura do log; usar
+	// time.Now() ali faria cada nó calcular um Deadline diferente conforme
+	// o instante em que aplicou a entrada, em vez do instante, igual para
+	// todos, em que ela foi proposta.
+	ProposedAt time.Time `json:"proposed_at"`
+}
+
+// ApplyResult é o que FSM.Apply devolve para cada Command processado - o
+// Future de Cluster.Apply o repassa ao chamador, que não precisa reconstruir
+// o estado resultante a partir do zero.
+type ApplyResult struct {
+	// Err é o erro de validação da mutação (poll inexistente, voto
+	// duplicado, opção inválida, prazo expirado etc.) - nil em caso de
+	// sucesso. Mesmo com Err setado, o Command ainda foi commitado no log:
+	// é uma rejeição de nível de aplicação, não uma falha de consenso.
+	Err error
+	// Cmd é o comando original, para quem recebe o resultado (ex.: o
+	// OnApply de internal/server) montar o anúncio sem precisar repassar o
+	// Command por fora.
+	Cmd Command
+	// VoteCounts é uma cópia do placar do poll após a mutação.
+	VoteCounts map[string]int
+	State      VotingState
+	Seq        uint64
+}
+
+// pollState é o estado replicado de um poll, espelhando os campos de
+// server.Poll que precisam sobreviver à queda de um líder: votos, placar,
+// fase da votação e prazo.
+type pollState struct {
+	Votes      map[string]string
+	VoteCounts map[string]int
+	State      VotingState
+	Deadline   time.Time
+	Seq        uint64
+}
+
+// FSM implementa raft.FSM: é a única responsável por mutar votes,
+// voteCounts e state de cada poll, e só o faz a partir de um log.Data já
+// commitado pela maioria do cluster.
+type FSM struct {
+	mu    sync.RWMutex
+	polls map[string]*pollState
+
+	// OnApply, se não nil, é chamado de forma síncrona ao final de cada
+	// Apply bem-sucedido ou rejeitado - em QUALQUER nó do cluster, líder ou
+	// seguidor, já que o Raft invoca FSM.Apply conforme o log replica. É
+	// assim que um seguidor propaga um UpdateMsg/PollEventMsg aos clientes
+	// conectados a ele sem nunca ter chamado Cluster.Apply diretamente.
+	// Deve ser atribuído antes do nó começar a participar do cluster (antes
+	// do primeiro AppendEntries) e nunca mudado depois: Apply o lê sem lock.
+	OnApply func(ApplyResult)
+}
+
+// NewFSM cria uma FSM vazia, sem nenhum poll conhecido ainda.
+func NewFSM() *FSM {
+	return &FSM{polls: make(map[string]*pollState)}
+}
+
+// Apply decodifica o Command de log.Data e muta o pollState correspondente,
+// criando-o na primeira referência. Chamado pelo Raft em ordem estrita de
+// log, nunca concorrentemente - FSM.mu protege as leituras feitas por Get
+// contra essas mutações, não a ordem delas entre si.
+func (f *FSM) Apply(log *raft.Log) interface{} {
+	var cmd Command
+	if err := json.Unmarshal(log.Data, &cmd); err != nil {
+		return ApplyResult{Err: fmt.Errorf("cluster: log corrompido: %w", err)}
+	}
+
+	f.mu.Lock()
+	ps, ok := f.polls[cmd.PollID]
+	if !ok {
+		ps = &pollState{
+			Votes:      make(map[string]string),
+			VoteCounts: make(map[string]int),
+			State:      StateNotStarted,
+		}
+		f.polls[cmd.PollID] = ps
+	}
+
+	var applyErr error
+	switch cmd.Type {
+	case CmdStartVoting:
+		if ps.State != StateNotStarted {
+			applyErr = fmt.Errorf("poll '%s' ja foi iniciado", cmd.PollID)
+			break
+		}
+		ps.State = StateActive
+		ps.Deadline = cmd.ProposedAt.Add(time.Duration(cmd.Duration) * time.Second)
+	case CmdVote:
+		switch {
+		case ps.State == StateNotStarted:
+			applyErr = fmt.Errorf("poll '%s' nao foi iniciado", cmd.PollID)
+		case ps.State == StateEnded:
+			applyErr = fmt.Errorf("poll '%s' ja foi encerrado", cmd.PollID)
+		case cmd.ProposedAt.After(ps.Deadline):
+			applyErr = fmt.Errorf("poll '%s' expirou", cmd.PollID)
+		default:
+			if _, already := ps.Votes[cmd.VoterID]; already {
+				applyErr = fmt.Errorf("%s ja votou em '%s'", cmd.VoterID, cmd.PollID)
+			} else {
+				ps.Votes[cmd.VoterID] = cmd.Option
+				ps.VoteCounts[cmd.Option]++
+				ps.Seq++
+			}
+		}
+	case CmdEndVoting:
+		if ps.State != StateActive {
+			applyErr = fmt.Errorf("poll '%s' nao esta ativo", cmd.PollID)
+			break
+		}
+		ps.State = StateEnded
+	default:
+		applyErr = fmt.Errorf("cluster: comando desconhecido: %s", cmd.Type)
+	}
+
+	result := ApplyResult{
+		Err:        applyErr,
+		Cmd:        cmd,
+		VoteCounts: cloneCounts(ps.VoteCounts),
+		State:      ps.State,
+		Seq:        ps.Seq,
+	}
+	f.mu.Unlock()
+
+	if f.OnApply != nil {
+		f.OnApply(result)
+	}
+	return result
+}
+
+// Get retorna uma cópia do estado replicado de um poll, segura para leitura
+// concorrente em qualquer nó - líder ou seguidor.
+func (f *FSM) Get(pollID string) (votes map[string]string, counts map[string]int, state VotingState, deadline time.Time, seq uint64, ok bool) {
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+	ps, found := f.polls[pollID]
+	if !found {
+		return nil, nil, "", time.Time{}, 0, false
+	}
+	return cloneVotes(ps.Votes), cloneCounts(ps.VoteCounts), ps.State, ps.Deadline, ps.Seq, true
+}
+
+// fsmSnapshot é o raft.FSMSnapshot produzido por FSM.Snapshot: uma cópia
+// imutável de todos os polls, serializada sob demanda por Persist.
+type fsmSnapshot struct {
+	polls map[string]*pollState
+}
+
+// Snapshot copia o estado de todos os polls para compactar o log do Raft.
+func (f *FSM) Snapshot() (raft.FSMSnapshot, error) {
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+
+	polls := make(map[string]*pollState, len(f.polls))
+	for id, ps := range f.polls {
+		polls[id] = &pollState{
+			Votes:      cloneVotes(ps.Votes),
+			VoteCounts: cloneCounts(ps.VoteCounts),
+			State:      ps.State,
+			Deadline:   ps.Deadline,
+			Seq:        ps.Seq,
+		}
+	}
+	return &fsmSnapshot{polls: polls}, nil
+}
+
+// Persist serializa o snapshot em JSON no sink fornecido pelo Raft.
+func (s *fsmSnapshot) Persist(sink raft.SnapshotSink) error {
+	err := func() error {
+		data, err := json.Marshal(s.polls)
+		if err != nil {
+			return fmt.Errorf("cluster: erro ao serializar snapshot: %w", err)
+		}
+		if _, err := sink.Write(data); err != nil {
+			return err
+		}
+		return sink.Close()
+	}()
+	if err != nil {
+		sink.Cancel()
+		return err
+	}
+	return nil
+}
+
+// Release não tem recursos a liberar: o snapshot é só um map em memória.
+func (s *fsmSnapshot) Release() {}
+
+// Restore substitui o estado da FSM pelo conteúdo de um snapshot, lido na
+// inicialização de um nó que está alcançando o resto do cluster.
+func (f *FSM) Restore(rc io.ReadCloser) error {
+	defer rc.Close()
+	var polls map[string]*pollState
+	if err := json.NewDecoder(rc).Decode(&polls); err != nil {
+		return fmt.Errorf("cluster: erro ao restaurar snapshot: %w", err)
+	}
+	f.mu.Lock()
+	f.polls = polls
+	f.mu.Unlock()
+	return nil
+}
+
+func cloneCounts(in map[string]int) map[string]int {
+	out := make(map[string]int, len(in))
+	for k, v := range in {
+		out[k] = v
+	}
+	return out
+}
+
+func cloneVotes(in map[string]string) map[string]string {
+	out := make(map[string]string, len(in))
+	for k, v := range in {
+		out[k] = v
+	}
+	return out
+}
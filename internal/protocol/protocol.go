@@ -0,0 +1,217 @@
+// Package protocol define as mensagens trocadas entre cliente e servidor e o
+// framing usado para transportá-las: um prefixo de tamanho de 4 bytes
+// big-endian seguido do JSON de um envelope {type, data}. Substitui o
+// protocolo de linha original (TrimSpace + HasPrefix), que não tinha como
+// representar estruturas (o antigo "UPDATE: %v\n" serializava um map do Go,
+// cuja ordem de iteração não é nem estável entre execuções).
+package protocol
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"io"
+)
+
+// MaxFrameSize limita o tamanho de um frame decodificado, para que um
+// prefixo de tamanho corrompido ou malicioso não faça o Decoder tentar
+// alocar um buffer arbitrariamente grande.
+const MaxFrameSize = 1 << 20 // 1 MiB
+
+// MessageType identifica o payload carregado por um envelope.
+type MessageType string
+
+const (
+	TypeHello     MessageType = "HELLO"
+	TypeWelcome   MessageType = "WELCOME"
+	TypeCreate    MessageType = "CREATE"
+	TypeJoin      MessageType = "JOIN"
+	TypeLeave     MessageType = "LEAVE"
+	TypeVote      MessageType = "VOTE"
+	TypeList      MessageType = "LIST"
+	TypePollList  MessageType = "POLL_LIST"
+	TypeUpdate    MessageType = "UPDATE"
+	TypePollEvent MessageType = "POLL_EVENT"
+	TypeOk        MessageType = "OK"
+	TypeError     MessageType = "ERROR"
+	TypeShutdown  MessageType = "SHUTDOWN"
+)
+
+// HelloMsg é a primeira mensagem enviada pelo cliente na conexão,
+// substituindo a antiga linha crua com o ID.
+type HelloMsg struct {
+	ID string `json:"id"`
+}
+
+// WelcomeMsg confirma o handshake e orienta o cliente sobre os comandos
+// disponíveis.
+type WelcomeMsg struct {
+	Message string `json:"message"`
+}
+
+// CreateMsg pede a criação de um novo poll.
+type CreateMsg struct {
+	PollID  string   `json:"poll_id"`
+	Options []string `json:"options"`
+}
+
+// JoinMsg inscreve o remetente nas atualizações de um poll; pré-requisito
+// para votar nele.
+type JoinMsg struct {
+	PollID string `json:"poll_id"`
+}
+
+// LeaveMsg cancela a inscrição do remetente em um poll.
+type LeaveMsg struct {
+	PollID string `json:"poll_id"`
+}
+
+// VoteMsg registra um voto em um poll.
+type VoteMsg struct {
+	PollID string `json:"poll_id"`
+	Option string `json:"option"`
+}
+
+// ListMsg pede a lista de polls existentes; não carrega campos.
+type ListMsg struct{}
+
+// PollInfo descreve um poll individual na resposta de ListMsg.
+type PollInfo struct {
+	ID    string `json:"id"`
+	State string `json:"state"`
+}
+
+// PollListMsg responde a ListMsg com o estado de todos os polls.
+type PollListMsg struct {
+	Polls []PollInfo `json:"polls"`
+}
+
+// UpdateMsg carrega o placar atualizado de um poll. Seq cresce
+// monotonicamente por poll, permitindo que o cliente detecte broadcasts
+// perdidos pela política de drop-on-full da fila por cliente (MODE_FANOUT).
+type UpdateMsg struct {
+	PollID string         `json:"poll_id"`
+	Counts map[string]int `json:"counts"`
+	Seq    uint64         `json:"seq"`
+
+	// Padding só é preenchido pelo demo de MODE_SYNC, que infla o frame para
+	// encher o TCP send buffer e tornar o bloqueio de poll.mu observável.
+	Padding string `json:"padding,omitempty"`
+}
+
+// PollEventMsg anuncia uma mudança de fase de um poll (início ou
+// encerramento da votação) a todos os seus inscritos.
+type PollEventMsg struct {
+	PollID  string `json:"poll_id"`
+	Event   string `json:"event"` // "STARTED" ou "ENDED"
+	Message string `json:"message"`
+}
+
+// OkMsg confirma uma operação que não carrega um placar associado (JOIN,
+// LEAVE, CREATE, VOTE).
+type OkMsg struct {
+	Message string `json:"message"`
+}
+
+// ErrorMsg relata a falha de uma requisição do cliente.
+type ErrorMsg struct {
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
+
+// ShutdownMsg avisa os clientes conectados que o servidor está encerrando.
+type ShutdownMsg struct {
+	Message string `json:"message"`
+}
+
+// envelope é o frame de fato trafegado na rede: o tipo mais o payload bruto,
+// permitindo decodificação em duas etapas (primeiro o tipo, depois o struct
+// concreto correspondente).
+type envelope struct {
+	Type MessageType     `json:"type"`
+	Data json.RawMessage `json:"data"`
+}
+
+// Encoder escreve mensagens tipadas como frames
+// [4 bytes big-endian de tamanho][JSON do envelope].
+type Encoder struct {
+	w io.Writer
+}
+
+// NewEncoder cria um Encoder que escreve frames em w.
+func NewEncoder(w io.Writer) *Encoder {
+	return &Encoder{w: w}
+}
+
+// Encode serializa payload, empacota-o num envelope com o tipo informado e
+// escreve o frame com prefixo de tamanho em w.
+func (e *Encoder) Encode(msgType MessageType, payload interface{}) error {
+	frame, err := EncodeFrame(msgType, payload)
+	if err != nil {
+		return err
+	}
+	if _, err := e.w.Write(frame); err != nil {
+		return fmt.Errorf("protocol: erro ao escrever frame %s: %w", msgType, err)
+	}
+	return nil
+}
+
+// EncodeFrame serializa payload num frame completo (prefixo de tamanho
+// incluído), sem precisar de um io.Writer imediato - útil para montar a
+// mensagem antes de enfileirá-la numa fila de saída por cliente.
+func EncodeFrame(msgType MessageType, payload interface{}) ([]byte, error) {
+	data, err := json.Marshal(payload)
+	if err != nil {
+		return nil, fmt.Errorf("protocol: erro ao serializar %s: %w", msgType, err)
+	}
+	env, err := json.Marshal(envelope{Type: msgType, Data: data})
+	if err != nil {
+		return nil, fmt.Errorf("protocol: erro ao serializar envelope %s: %w", msgType, err)
+	}
+	if len(env) > MaxFrameSize {
+		return nil, fmt.Errorf("protocol: frame %s excede MaxFrameSize (%d > %d)", msgType, len(env), MaxFrameSize)
+	}
+
+	buf := bytes.NewBuffer(make([]byte, 0, 4+len(env)))
+	var header [4]byte
+	binary.BigEndian.PutUint32(header[:], uint32(len(env)))
+	buf.Write(header[:])
+	buf.Write(env)
+	return buf.Bytes(), nil
+}
+
+// Decoder lê frames [4 bytes big-endian de tamanho][JSON do envelope] de r.
+type Decoder struct {
+	r io.Reader
+}
+
+// NewDecoder cria um Decoder que lê frames de r.
+func NewDecoder(r io.Reader) *Decoder {
+	return &Decoder{r: r}
+}
+
+// Decode lê o próximo frame e retorna seu tipo e payload bruto; o chamador
+// decodifica o payload com json.Unmarshal para o struct correspondente ao
+// tipo retornado.
+func (d *Decoder) Decode() (MessageType, json.RawMessage, error) {
+	var header [4]byte
+	if _, err := io.ReadFull(d.r, header[:]); err != nil {
+		return "", nil, err
+	}
+	size := binary.BigEndian.Uint32(header[:])
+	if size > MaxFrameSize {
+		return "", nil, fmt.Errorf("protocol: frame de %d bytes excede MaxFrameSize (%d)", size, MaxFrameSize)
+	}
+
+	buf := make([]byte, size)
+	if _, err := io.ReadFull(d.r, buf); err != nil {
+		return "", nil, err
+	}
+
+	var env envelope
+	if err := json.Unmarshal(buf, &env); err != nil {
+		return "", nil, fmt.Errorf("protocol: erro ao decodificar envelope: %w", err)
+	}
+	return env.Type, env.Data, nil
+}
@@ -0,0 +1,72 @@
+package server
+
+import (
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/yLukas077/tcp-vote/internal/protocol"
+)
+
+// TestBroadcastPollLockedFitsMaxFrameSize reproduz o cenário do modo
+// ModeSync com um conjunto realista de inscritos: o frame do placar,
+// inflado pelo padding didático de broadcastPollLocked, precisa sobreviver
+// ao envelope JSON de EncodeFrame sem estourar protocol.MaxFrameSize - um
+// byte nulo no padding escapa para 6 bytes em JSON e já estourava esse
+// limite sozinho, antes de qualquer subscriber existir.
+func TestBroadcastPollLockedFitsMaxFrameSize(t *testing.T) {
+	s := NewServer(ModeSync)
+	if err := s.CreatePoll("p1", []string{"A", "B"}); err != nil {
+		t.Fatalf("CreatePoll: %v", err)
+	}
+	poll := s.getPoll("p1")
+	poll.voteCounts["A"] = 3
+	poll.voteCounts["B"] = 1
+
+	const subscriberCount = 3
+	var wg sync.WaitGroup
+	for i := 0; i < subscriberCount; i++ {
+		serverSide, clientSide := net.Pipe()
+		id := "voter" + string(rune('0'+i))
+
+		mu := &sync.Mutex{}
+		cc := newClientConn(&clientStreams{
+			control: serverSide, broadcast: serverSide, event: serverSide,
+			controlMu: mu, broadcastMu: mu, eventMu: mu,
+		})
+		s.mu.Lock()
+		s.clients[id] = cc
+		s.mu.Unlock()
+		poll.subscribers[id] = struct{}{}
+
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			dec := protocol.NewDecoder(clientSide)
+			msgType, _, err := dec.Decode()
+			if err != nil {
+				t.Errorf("decode do subscriber %s: %v", id, err)
+				return
+			}
+			if msgType != protocol.TypeUpdate {
+				t.Errorf("subscriber %s recebeu %s, esperava %s", id, msgType, protocol.TypeUpdate)
+			}
+		}()
+	}
+
+	done := make(chan struct{})
+	go func() {
+		poll.mu.Lock()
+		s.broadcastPollLocked(poll, 1)
+		poll.mu.Unlock()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("broadcastPollLocked não retornou a tempo (frame provavelmente rejeitado por EncodeFrame)")
+	}
+	wg.Wait()
+}
@@ -0,0 +1,130 @@
+package server
+
+import (
+	"expvar"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	_ "net/http/pprof" // registra /debug/pprof/* em http.DefaultServeMux
+	"sync/atomic"
+)
+
+// metricsInstanceSeq numera cada Server criado no processo, para que seus
+// nomes de variável expvar (globais ao processo em expvar.DefaultMap, não
+// por instância) não colidam entre si - expvar.NewInt/Publish entram em
+// panic ("Reuse of exported var name") na segunda chamada com o mesmo nome,
+// o que aconteceria em qualquer processo que suba mais de um Server (ex.:
+// um teste com vários nós de um cluster via NewClusteredServer).
+var metricsInstanceSeq int64
+
+// Metrics agrupa os contadores expvar do servidor. Cada campo é publicado em
+// /debug/vars assim que o Server é criado, dando uma história quantitativa
+// real para a comparação didática entre os modos de broadcast.
+type Metrics struct {
+	VotesAccepted               *expvar.Int
+	VotesRejectedInvalid        *expvar.Int
+	VotesRejectedDuplicate      *expvar.Int
+	ClientsConnected            *expvar.Int
+	ClientsDisconnected         *expvar.Int
+	BroadcastsSent              *expvar.Int
+	BroadcastsDroppedSlowClient *expvar.Int
+	WriteTimeouts               *expvar.Int
+	ConnectionsRefusedBusy      *expvar.Int
+	OutboxFramesDropped         *expvar.Int
+}
+
+// newMetrics publica os contadores e os gauges (active_clients, occupancy do
+// broadcastChan) em expvar.DefaultMap, amarrados à instância s. Os nomes
+// levam o sufixo da instância (ver metricsInstanceSeq) para que um segundo
+// Server no mesmo processo não colida com o primeiro.
+func newMetrics(s *Server) *Metrics {
+	seq := atomic.AddInt64(&metricsInstanceSeq, 1) - 1
+	name := func(base string) string {
+		if seq == 0 {
+			return base
+		}
+		return fmt.Sprintf("%s_%d", base, seq)
+	}
+
+	m := &Metrics{
+		VotesAccepted:               expvar.NewInt(name("votes_accepted")),
+		VotesRejectedInvalid:        expvar.NewInt(name("votes_rejected_invalid")),
+		VotesRejectedDuplicate:      expvar.NewInt(name("votes_rejected_duplicate")),
+		ClientsConnected:            expvar.NewInt(name("clients_connected")),
+		ClientsDisconnected:         expvar.NewInt(name("clients_disconnected")),
+		BroadcastsSent:              expvar.NewInt(name("broadcasts_sent")),
+		BroadcastsDroppedSlowClient: expvar.NewInt(name("broadcasts_dropped_slow_client")),
+		WriteTimeouts:               expvar.NewInt(name("write_timeouts")),
+		ConnectionsRefusedBusy:      expvar.NewInt(name("connections_refused_busy")),
+		OutboxFramesDropped:         expvar.NewInt(name("outbox_frames_dropped_total")),
+	}
+
+	expvar.Publish(name("active_clients"), expvar.Func(func() interface{} {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		return len(s.clients)
+	}))
+
+	expvar.Publish(name("broadcast_chan_occupancy"), expvar.Func(func() interface{} {
+		return len(s.broadcastChan)
+	}))
+
+	expvar.Publish(name("admission_slots_in_use"), expvar.Func(func() interface{} {
+		return len(s.sem)
+	}))
+
+	// outbox_high_watermark é o maior número de UpdateMsg não drenados já
+	// observado em qualquer outbox de placar (ver outbox.go) de qualquer
+	// cliente - um valor próximo de clientOutboxCapacity indica clientes
+	// cronicamente lentos para ler o placar.
+	expvar.Publish(name("outbox_high_watermark"), expvar.Func(func() interface{} {
+		s.mu.Lock()
+		clients := make([]*clientConn, 0, len(s.clients))
+		for _, cc := range s.clients {
+			clients = append(clients, cc)
+		}
+		s.mu.Unlock()
+
+		var max int64
+		for _, cc := range clients {
+			cc.outboxesMu.Lock()
+			for _, ob := range cc.outboxes {
+				if hw := ob.HighWatermark(); hw > max {
+					max = hw
+				}
+			}
+			cc.outboxesMu.Unlock()
+		}
+		return max
+	}))
+
+	return m
+}
+
+// StartMetrics sobe um http.Server auxiliar servindo /debug/vars,
+// /debug/pprof/* e /healthz. addr vazio vira "127.0.0.1:0" (porta efêmera,
+// só acessível localmente, como o gops). Retorna o endereço efetivo.
+func (s *Server) StartMetrics(addr string) (string, error) {
+	if addr == "" {
+		addr = "127.0.0.1:0"
+	}
+
+	ln, err := net.Listen("tcp", addr)
+	if err != nil {
+		return "", fmt.Errorf("metrics: erro ao escutar em %s: %w", addr, err)
+	}
+
+	http.HandleFunc("/healthz", func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintln(w, "ok")
+	})
+
+	go func() {
+		log.Printf("Sidecar de métricas em http://%s/debug/vars", ln.Addr())
+		if err := http.Serve(ln, nil); err != nil {
+			log.Printf("Sidecar de métricas encerrado: %v", err)
+		}
+	}()
+
+	return ln.Addr().String(), nil
+}
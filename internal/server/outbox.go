@@ -0,0 +1,142 @@
+package server
+
+import (
+	"sync"
+	"time"
+)
+
+// clientOutboxCapacity é o tamanho (potência de dois) de clientOutbox.buf;
+// mask = clientOutboxCapacity-1 localiza o slot de um índice monotônico sem
+// precisar de módulo.
+const clientOutboxCapacity = 16
+
+// clientOutbox é a fila circular de frames de placar (UpdateMsg) já
+// codificados de um cliente em MODE_FANOUT. Ao contrário da fila genérica de
+// enqueueOrWrite, que descarta o frame mais recente quando cheia, um
+// UpdateMsg só carrega o placar mais atual de um poll - descartar uma
+// atualização intermediária não perde informação nenhuma, desde que a mais
+// nova sempre sobreviva. Por isso Push nunca bloqueia e nunca recusa um
+// frame: ao encontrar o ring cheio, avança readIndex (descarta a entrada
+// mais antiga ainda não lida) antes de escrever, preservando esse invariante.
+type clientOutbox struct {
+	mu   sync.Mutex
+	buf  [][]byte
+	mask uint64
+
+	readIndex  uint64
+	writeIndex uint64
+	closed     bool
+
+	notEmpty *sync.Cond
+	// notFull não tem hoje nenhum produtor esperando nela - Push nunca
+	// bloqueia - mas é sinalizada a cada Pop para manter o par
+	// produtor/consumidor completo caso uma fila não coalescível passe a
+	// usar este mesmo tipo no futuro.
+	notFull *sync.Cond
+
+	dropped       int64
+	highWatermark int64
+
+	// fullSince é quando o ring começou a ficar continuamente cheio; zero
+	// quando não está cheio agora. Usado por Push para informar ao chamador
+	// (sendToPoll) por quanto tempo este cliente está sem conseguir drenar o
+	// placar, para aplicar Server.SlowClientDeadline.
+	fullSince time.Time
+}
+
+func newClientOutbox() *clientOutbox {
+	ob := &clientOutbox{
+		buf:  make([][]byte, clientOutboxCapacity),
+		mask: clientOutboxCapacity - 1,
+	}
+	ob.notEmpty = sync.NewCond(&ob.mu)
+	ob.notFull = sync.NewCond(&ob.mu)
+	return ob
+}
+
+// occupiedLocked conta quantas entradas não lidas existem agora; chamado com
+// ob.mu já travado.
+func (ob *clientOutbox) occupiedLocked() uint64 {
+	return ob.writeIndex - ob.readIndex
+}
+
+// Push insere frame, descartando a entrada mais antiga ainda não lida se o
+// ring já estiver cheio (dropped=true). slowFor é por quanto tempo o ring
+// está continuamente cheio neste momento, zero se não está cheio agora.
+func (ob *clientOutbox) Push(frame []byte) (dropped bool, slowFor time.Duration) {
+	ob.mu.Lock()
+	defer ob.mu.Unlock()
+
+	if ob.closed {
+		return false, 0
+	}
+
+	full := ob.occupiedLocked() == clientOutboxCapacity
+	if full {
+		ob.readIndex++ // descarta a entrada mais antiga ainda não lida
+		ob.dropped++
+		if ob.fullSince.IsZero() {
+			ob.fullSince = time.Now()
+		}
+	} else {
+		ob.fullSince = time.Time{}
+	}
+
+	ob.buf[ob.writeIndex&ob.mask] = frame
+	ob.writeIndex++
+
+	if occ := int64(ob.occupiedLocked()); occ > ob.highWatermark {
+		ob.highWatermark = occ
+	}
+
+	ob.notEmpty.Signal()
+
+	if full {
+		return true, time.Since(ob.fullSince)
+	}
+	return false, 0
+}
+
+// Pop bloqueia (sem busy-wait) até haver um frame disponível ou o outbox ser
+// fechado por Close.
+func (ob *clientOutbox) Pop() (frame []byte, ok bool) {
+	ob.mu.Lock()
+	defer ob.mu.Unlock()
+
+	for ob.occupiedLocked() == 0 && !ob.closed {
+		ob.notEmpty.Wait()
+	}
+	if ob.occupiedLocked() == 0 {
+		return nil, false // fechado e vazio
+	}
+
+	frame = ob.buf[ob.readIndex&ob.mask]
+	ob.buf[ob.readIndex&ob.mask] = nil
+	ob.readIndex++
+	ob.fullSince = time.Time{}
+	ob.notFull.Signal()
+	return frame, true
+}
+
+// Close sinaliza toda goroutine bloqueada em Pop a retornar; Pop passa a
+// devolver ok=false assim que drenar o que sobrou.
+func (ob *clientOutbox) Close() {
+	ob.mu.Lock()
+	ob.closed = true
+	ob.mu.Unlock()
+	ob.notEmpty.Broadcast()
+}
+
+// Dropped retorna quantos frames este outbox descartou por estar cheio.
+func (ob *clientOutbox) Dropped() int64 {
+	ob.mu.Lock()
+	defer ob.mu.Unlock()
+	return ob.dropped
+}
+
+// HighWatermark retorna a maior ocupação já observada neste outbox.
+func (ob *clientOutbox) HighWatermark() int64 {
+	ob.mu.Lock()
+	defer ob.mu.Unlock()
+	return ob.highWatermark
+}
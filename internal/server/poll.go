@@ -0,0 +1,82 @@
+package server
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+)
+
+// VotingOptions encapsula as opções de voto disponíveis de um poll.
+type VotingOptions struct {
+	List          []string
+	DisplayString string
+}
+
+// Poll representa uma votação isolada e nomeada dentro do servidor. Cada Poll
+// tem seu próprio RWMutex, então atividade em um poll (votos, broadcasts)
+// nunca contende com a de outro - ao contrário do antigo estado único e
+// global do servidor.
+type Poll struct {
+	mu sync.RWMutex
+
+	ID      string
+	Options VotingOptions
+
+	votes       map[string]string   // voterID -> opção escolhida
+	voteCounts  map[string]int      // placar agregado
+	subscribers map[string]struct{} // IDs de clientes inscritos via JOIN
+
+	state    VotingState
+	deadline time.Time
+
+	// seq cresce a cada UpdateMsg deste poll, permitindo que o cliente
+	// detecte broadcasts perdidos pela política de drop-on-full da fila por
+	// cliente (MODE_FANOUT). Protegido por mu, como os demais campos acima.
+	seq uint64
+}
+
+// newPoll cria um Poll com placar zerado para a lista de opções informada.
+func newPoll(id string, optionsList []string) *Poll {
+	p := &Poll{
+		ID: id,
+		Options: VotingOptions{
+			List:          optionsList,
+			DisplayString: strings.Join(optionsList, ", "),
+		},
+		votes:       make(map[string]string),
+		voteCounts:  make(map[string]int),
+		subscribers: make(map[string]struct{}),
+		state:       VotingNotStarted,
+	}
+	for _, op := range optionsList {
+		p.voteCounts[op] = 0
+	}
+	return p
+}
+
+// statusLocked descreve o estado atual do poll para um cliente que acabou de
+// entrar (JOIN). Assume p.mu já travado (leitura ou escrita) pelo chamador.
+func (p *Poll) statusLocked() string {
+	switch p.state {
+	case VotingNotStarted:
+		return "Aguardando inicio da votacao..."
+	case VotingActive:
+		remaining := time.Until(p.deadline).Round(time.Second)
+		return fmt.Sprintf("Votacao em andamento! Tempo restante: %s. Opcoes: [%s]",
+			remaining, p.Options.DisplayString)
+	case VotingEnded:
+		return fmt.Sprintf("Votacao encerrada. Resultado: %v", p.voteCounts)
+	default:
+		return ""
+	}
+}
+
+// cloneCounts copia o placar para uso fora da seção crítica do poll (ModeAsync).
+func cloneCounts(src map[string]int) map[string]int {
+	dst := make(map[string]int, len(src))
+	for k, v := range src {
+		dst[k] = v
+	}
+	return dst
+}
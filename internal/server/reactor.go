@@ -0,0 +1,49 @@
+package server
+
+import "net"
+
+// IOModel seleciona como Server.Start multiplexa a I/O das conexões aceitas.
+type IOModel int
+
+const (
+	// IOModelGoroutine é o modelo original: uma goroutine bloqueada em
+	// Decode por conexão. Simples e correto, mas um fan-out muito grande
+	// (milhares de clientes majoritariamente ociosos) paga o custo de uma
+	// goroutine + stack para cada um só para esperar dados que quase nunca
+	// chegam.
+	IOModelGoroutine IOModel = iota
+	// IOModelReactor troca a goroutine por conexão por um loop de eventos
+	// baseado em epoll (ver reactor_linux.go): um pool pequeno de
+	// goroutines, do tamanho de GOMAXPROCS, drena os descritores que o
+	// kernel sinaliza como prontos. Só disponível em Linux; noutras
+	// plataformas newReactor (ver reactor_other.go) devolve erro e Start
+	// recusa-se a subir nesse modo.
+	IOModelReactor
+)
+
+func (m IOModel) String() string {
+	switch m {
+	case IOModelGoroutine:
+		return "GOROUTINE"
+	case IOModelReactor:
+		return "REACTOR"
+	default:
+		return "DESCONHECIDO"
+	}
+}
+
+// ioReactor é implementado pelo loop epoll de reactor_linux.go. Declarado
+// aqui, num arquivo sem build tag, para que Server possa guardar um campo
+// deste tipo e compilar em qualquer plataforma mesmo quando a única
+// implementação real é Linux-only.
+type ioReactor interface {
+	// register assume a conexão aceita: configura-a como não-bloqueante,
+	// registra seu fd no epoll e conduz handshake/comandos/escritas a
+	// partir daí. Devolve erro se conn não for utilizável pelo reator (ex.:
+	// não é *net.TCPConn).
+	register(conn net.Conn) error
+	// close encerra o epoll e, com ele, todo o pool de workers; não fecha
+	// as conexões registradas - as que já completaram o HELLO são fechadas
+	// por Server.Shutdown junto com os demais clientes.
+	close()
+}
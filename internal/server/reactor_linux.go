@@ -0,0 +1,543 @@
+//go:build linux
+
+package server
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net"
+	"runtime"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/sys/unix"
+
+	"github.com/yLukas077/tcp-vote/internal/protocol"
+)
+
+// reactor é o backend de IOModelReactor: em vez de uma goroutine bloqueada
+// em Decode por conexão, uma única goroutine de espera chama epoll_wait e
+// distribui os fds prontos para leitura a um pool de workers do tamanho de
+// GOMAXPROCS, que fazem a leitura, a remontagem de frames e o despacho de
+// comandos. Escritas nunca bloqueiam a goroutine que as originou: reactorConn
+// .Write (ver abaixo) tenta um write() não-bloqueante e, em EAGAIN, enfileira
+// o restante e pede ao loop para avisar quando o fd voltar a ser gravável
+// (EPOLLOUT), em vez de travar broadcastPollLocked/broadcastWorker como o
+// modelo goroutine-por-conexão faria.
+//
+// O protocolo continua sendo o framing de internal/protocol (prefixo de
+// tamanho de 4 bytes + envelope JSON); não há nada "delimitado por linha"
+// para reaproveitar aqui, então extractFrame remonta frames incrementalmente
+// a partir do que cada EPOLLIN entrega, em vez de varrer por '\n'.
+//
+// Limitação conhecida: IOModelReactor só suporta TransportRaw. TransportMux
+// depende de uma sessão yamux, que mantém suas próprias goroutines de leitura
+// internamente - exatamente o custo por conexão que o reator existe para
+// evitar -, então combinar os dois não faria sentido; se Server.Transport for
+// TransportMux, o reator trata a conexão como se fosse Raw (um único fluxo
+// físico para controle/placar/eventos).
+type reactor struct {
+	s    *Server
+	epfd int
+
+	jobs chan int // fds prontos para leitura, consumidos pelo pool de workers
+
+	connsMu sync.Mutex
+	conns   map[int]*fdConn
+}
+
+// fdConn é o estado por conexão mantido pelo reator: o fd cru usado nas
+// chamadas de epoll_ctl/read/write, o net.Conn original (só para
+// Close/RemoteAddr), o estado do handshake e o buffer de bytes acumulados
+// entre despertares EPOLLET até formarem um frame completo.
+type fdConn struct {
+	fd     int
+	raw    net.Conn
+	writer *reactorConn
+
+	readBuf []byte
+
+	registered bool
+	id         string
+	cc         *clientConn
+
+	// closeOnce garante que closeConn rode uma única vez por fdConn: loop()
+	// (ao ver EPOLLHUP|EPOLLERR) e um worker() (dentro de handleReadable, ao
+	// ver erro/EOF de leitura) podem chamar closeConn para o mesmo fd de
+	// goroutines diferentes - sem isso, a segunda chamada bloquearia para
+	// sempre em r.s.releaseSlot() (não é idempotente) e travaria loop(), o
+	// único despachante de epoll_wait, parando o reator inteiro.
+	closeOnce sync.Once
+}
+
+// reactorPendingCap é o tamanho máximo (em bytes) que reactorConn.pending
+// pode acumular enquanto o fd não está gravável, no mesmo espírito das
+// filas limitadas de enqueueOrWrite e do ring buffer de outbox.go: nenhum
+// buffer por conexão pode crescer sem limite só porque o leitor do outro
+// lado parou de drenar o socket. Dimensionado como alguns frames no maior
+// tamanho aceito pelo protocolo (protocol.MaxFrameSize).
+const reactorPendingCap = 4 * protocol.MaxFrameSize
+
+// errReactorPendingFull é devolvido por Write quando aceitar p faria
+// rc.pending exceder reactorPendingCap, ou quando o prazo de escrita vence
+// com rc.pending ainda não escoado - implementa net.Error com
+// Timeout()==true para que writeConn (que já só distingue "erro" de "erro
+// de timeout" via essa interface) conte e trate como qualquer outro estouro
+// de prazo de escrita, encerrando a conexão do cliente lento.
+type errReactorPendingFull struct{}
+
+func (errReactorPendingFull) Error() string {
+	return "server: reactorConn.pending excedeu o limite, cliente lento demais para ler"
+}
+func (errReactorPendingFull) Timeout() bool   { return true }
+func (errReactorPendingFull) Temporary() bool { return false }
+
+// reactorConn adapta o fd de uma conexão do reator à interface net.Conn
+// exigida pelo resto do servidor (clientStreams, writeConn, pollSenderLoop):
+// só Write tem uma implementação real, cooperando com o loop de epoll em vez
+// de bloquear em EAGAIN; Read nunca é chamado porque é o loop, não um
+// consumidor de clientStreams, quem lê o fd cru.
+type reactorConn struct {
+	fd  int
+	raw net.Conn
+	r   *reactor
+
+	writeMu  sync.Mutex
+	pending  []byte    // cauda não escrita, aguardando o fd voltar a ser gravável
+	deadline time.Time // prazo armado pelo último SetWriteDeadline, zero se nenhum
+}
+
+func (rc *reactorConn) Write(p []byte) (int, error) {
+	rc.writeMu.Lock()
+	defer rc.writeMu.Unlock()
+
+	if !rc.deadline.IsZero() && len(rc.pending) > 0 && time.Now().After(rc.deadline) {
+		return 0, errReactorPendingFull{}
+	}
+
+	if len(rc.pending) > 0 {
+		// Já existe uma escrita pendente: entra na fila atrás dela. O dreno
+		// de EPOLLOUT escreve rc.pending inteiro antes de aceitar mais
+		// dados, preservando a ordem dos frames.
+		if len(rc.pending)+len(p) > reactorPendingCap {
+			return 0, errReactorPendingFull{}
+		}
+		rc.pending = append(rc.pending, p...)
+		return len(p), nil
+	}
+
+	n, err := unix.Write(rc.fd, p)
+	if err != nil {
+		if err == unix.EAGAIN || err == unix.EWOULDBLOCK {
+			if len(p) > reactorPendingCap {
+				return 0, errReactorPendingFull{}
+			}
+			rc.pending = append(rc.pending, p...)
+			rc.r.armWrite(rc.fd)
+			return len(p), nil
+		}
+		return 0, err
+	}
+	if n < len(p) {
+		if len(p[n:]) > reactorPendingCap {
+			return 0, errReactorPendingFull{}
+		}
+		rc.pending = append(rc.pending, p[n:]...)
+		rc.r.armWrite(rc.fd)
+	}
+	return len(p), nil
+}
+
+// drainWrite é chamado pelo loop quando o fd sinaliza EPOLLOUT: tenta
+// escoar rc.pending e desarma o interesse em EPOLLOUT assim que esvaziar.
+func (rc *reactorConn) drainWrite() error {
+	rc.writeMu.Lock()
+	defer rc.writeMu.Unlock()
+
+	for len(rc.pending) > 0 {
+		n, err := unix.Write(rc.fd, rc.pending)
+		if err != nil {
+			if err == unix.EAGAIN || err == unix.EWOULDBLOCK {
+				return nil // ainda bloqueado; espera o próximo EPOLLOUT
+			}
+			return err
+		}
+		rc.pending = rc.pending[n:]
+	}
+	rc.r.disarmWrite(rc.fd)
+	return nil
+}
+
+// Read não é suportado: a leitura do fd é feita por reactor.handleReadable,
+// nunca por um consumidor de clientStreams.
+func (rc *reactorConn) Read(p []byte) (int, error) {
+	return 0, fmt.Errorf("server: reactorConn.Read não é suportado, a leitura é feita pelo loop do reator")
+}
+func (rc *reactorConn) Close() error         { return rc.raw.Close() }
+func (rc *reactorConn) LocalAddr() net.Addr  { return rc.raw.LocalAddr() }
+func (rc *reactorConn) RemoteAddr() net.Addr { return rc.raw.RemoteAddr() }
+
+// SetDeadline e SetReadDeadline continuam no-op: o reator nunca bloqueia
+// lendo um fd individual (handleReadable só lê até EAGAIN quando o loop já
+// sinalizou EPOLLIN), então não há uma chamada bloqueante para um prazo de
+// leitura interromper aqui - ao contrário de SetWriteDeadline, cujo prazo
+// writeConn de fato depende de Write observar (ver acima).
+func (rc *reactorConn) SetDeadline(t time.Time) error     { return nil }
+func (rc *reactorConn) SetReadDeadline(t time.Time) error { return nil }
+
+// SetWriteDeadline arma o prazo que Write usa para recusar (em vez de
+// acumular indefinidamente) uma escrita enquanto o fd segue não-gravável -
+// sem isto, só o estouro de reactorPendingCap contaria em WriteTimeouts para
+// conexões do reator. Enquanto rc.pending não esvazia, o prazo já armado
+// (o da escrita que ficou pendente) não é substituído: writeConn arma um
+// prazo novo antes de cada Write, que de outro modo nunca estaria vencido.
+func (rc *reactorConn) SetWriteDeadline(t time.Time) error {
+	rc.writeMu.Lock()
+	if len(rc.pending) == 0 || rc.deadline.IsZero() {
+		rc.deadline = t
+	}
+	rc.writeMu.Unlock()
+	return nil
+}
+
+// newReactor sobe o epoll deste processo e seu pool de workers, do tamanho
+// de GOMAXPROCS conforme pedido pela requisição original. Chamado por
+// Server.Start quando s.IOModel é IOModelReactor.
+func newReactor(s *Server) (ioReactor, error) {
+	epfd, err := unix.EpollCreate1(0)
+	if err != nil {
+		return nil, fmt.Errorf("server: erro ao criar epoll: %w", err)
+	}
+
+	r := &reactor{
+		s:     s,
+		epfd:  epfd,
+		jobs:  make(chan int, 1024),
+		conns: make(map[int]*fdConn),
+	}
+
+	workers := runtime.GOMAXPROCS(0)
+	for i := 0; i < workers; i++ {
+		s.wg.Add(1)
+		go r.worker()
+	}
+	s.wg.Add(1)
+	go r.loop()
+
+	log.Printf("[REACTOR] epoll iniciado com %d workers", workers)
+	return r, nil
+}
+
+// register assume uma conexão recém aceita: torna seu fd não-bloqueante e o
+// registra no epoll com EPOLLIN|EPOLLET (notificação por borda - cada
+// despertar exige ler até EAGAIN, nunca supor que sobrou dado algum).
+func (r *reactor) register(conn net.Conn) error {
+	tcpConn, ok := conn.(*net.TCPConn)
+	if !ok {
+		return fmt.Errorf("server: reator requer uma conexao TCP, recebeu %T", conn)
+	}
+	tcpConn.SetKeepAlive(true)
+	tcpConn.SetKeepAlivePeriod(r.s.KeepAlivePeriod)
+
+	sysConn, err := tcpConn.SyscallConn()
+	if err != nil {
+		return fmt.Errorf("server: erro ao acessar fd da conexao: %w", err)
+	}
+
+	var fd int
+	var ctrlErr error
+	if err := sysConn.Control(func(sysfd uintptr) {
+		fd = int(sysfd)
+		ctrlErr = unix.SetNonblock(fd, true)
+	}); err != nil {
+		return fmt.Errorf("server: erro ao obter fd bruto: %w", err)
+	}
+	if ctrlErr != nil {
+		return fmt.Errorf("server: erro ao configurar O_NONBLOCK: %w", ctrlErr)
+	}
+
+	writer := &reactorConn{fd: fd, raw: conn, r: r}
+	fc := &fdConn{fd: fd, raw: conn, writer: writer}
+
+	r.connsMu.Lock()
+	r.conns[fd] = fc
+	r.connsMu.Unlock()
+
+	ev := unix.EpollEvent{Events: unix.EPOLLIN | unix.EPOLLET, Fd: int32(fd)}
+	if err := unix.EpollCtl(r.epfd, unix.EPOLL_CTL_ADD, fd, &ev); err != nil {
+		r.connsMu.Lock()
+		delete(r.conns, fd)
+		r.connsMu.Unlock()
+		return fmt.Errorf("server: erro ao registrar fd %d no epoll: %w", fd, err)
+	}
+	return nil
+}
+
+// armWrite adiciona EPOLLOUT ao interesse de fd: chamado assim que um write()
+// não-bloqueante devolve EAGAIN, para o loop avisar quando houver espaço de
+// novo no buffer de envio do kernel.
+func (r *reactor) armWrite(fd int) {
+	ev := unix.EpollEvent{Events: unix.EPOLLIN | unix.EPOLLOUT | unix.EPOLLET, Fd: int32(fd)}
+	if err := unix.EpollCtl(r.epfd, unix.EPOLL_CTL_MOD, fd, &ev); err != nil {
+		log.Printf("[REACTOR] erro ao armar EPOLLOUT para fd %d: %v", fd, err)
+	}
+}
+
+// disarmWrite remove EPOLLOUT do interesse de fd assim que a fila de escrita
+// pendente esvazia - manter EPOLLOUT armado sem nada para escrever faria o
+// loop acordar a cada iteração à toa (o fd está sempre gravável em repouso).
+func (r *reactor) disarmWrite(fd int) {
+	ev := unix.EpollEvent{Events: unix.EPOLLIN | unix.EPOLLET, Fd: int32(fd)}
+	if err := unix.EpollCtl(r.epfd, unix.EPOLL_CTL_MOD, fd, &ev); err != nil {
+		log.Printf("[REACTOR] erro ao desarmar EPOLLOUT para fd %d: %v", fd, err)
+	}
+}
+
+// loop é a única goroutine que chama epoll_wait; despacha EPOLLOUT
+// imediatamente (drainWrite é rápido e não bloqueia) e EPOLLIN para o pool de
+// workers via r.jobs, para que uma leitura/dispatch lenta num cliente nunca
+// atrase o aviso de prontidão dos demais.
+func (r *reactor) loop() {
+	defer r.s.wg.Done()
+	events := make([]unix.EpollEvent, 256)
+	for {
+		// Timeout finito: fechar epfd (reactor.close) não acorda um
+		// epoll_wait já bloqueado; é a chamada seguinte que devolve erro.
+		n, err := unix.EpollWait(r.epfd, events, 500)
+		if err != nil {
+			if err == unix.EINTR {
+				continue
+			}
+			// epfd fechado por reactor.close (Shutdown) ou erro irrecuperável.
+			close(r.jobs)
+			return
+		}
+
+		for i := 0; i < n; i++ {
+			fd := int(events[i].Fd)
+			r.connsMu.Lock()
+			fc, ok := r.conns[fd]
+			r.connsMu.Unlock()
+			if !ok {
+				continue
+			}
+
+			ev := events[i].Events
+			if ev&(unix.EPOLLHUP|unix.EPOLLERR) != 0 {
+				r.closeConn(fc)
+				continue
+			}
+			if ev&unix.EPOLLOUT != 0 {
+				if err := fc.writer.drainWrite(); err != nil {
+					r.closeConn(fc)
+					continue
+				}
+			}
+			if ev&unix.EPOLLIN != 0 {
+				select {
+				case r.jobs <- fd:
+				default:
+					// Pool de workers saturado: processa aqui mesmo para não
+					// perder a notificação - EPOLLET não reavisa sozinho.
+					r.handleReadable(fc)
+				}
+			}
+		}
+	}
+}
+
+// worker drena r.jobs processando a leitura e o despacho de cada fd pronto.
+func (r *reactor) worker() {
+	defer r.s.wg.Done()
+	for fd := range r.jobs {
+		r.connsMu.Lock()
+		fc, ok := r.conns[fd]
+		r.connsMu.Unlock()
+		if !ok {
+			continue // fechado entre o aviso do loop e este worker pegá-lo
+		}
+		r.handleReadable(fc)
+	}
+}
+
+// handleReadable esvazia o fd (EPOLLET exige ler até EAGAIN) acumulando em
+// fc.readBuf, depois extrai e despacha todo frame completo que já tiver
+// chegado, deixando um frame parcial no buffer para o próximo despertar.
+func (r *reactor) handleReadable(fc *fdConn) {
+	buf := make([]byte, 64*1024)
+	for {
+		n, err := unix.Read(fc.fd, buf)
+		if n > 0 {
+			fc.readBuf = append(fc.readBuf, buf[:n]...)
+		}
+		if err != nil {
+			if err == unix.EAGAIN || err == unix.EWOULDBLOCK {
+				break
+			}
+			r.closeConn(fc)
+			return
+		}
+		if n == 0 {
+			r.closeConn(fc) // FIN do peer: EOF
+			return
+		}
+	}
+
+	for {
+		frame, rest, ok, err := extractFrame(fc.readBuf)
+		if err != nil {
+			log.Printf("[REACTOR] frame invalido de fd %d: %v", fc.fd, err)
+			r.closeConn(fc)
+			return
+		}
+		if !ok {
+			fc.readBuf = rest
+			break
+		}
+		fc.readBuf = rest
+		r.dispatchFrame(fc, frame)
+	}
+}
+
+// extractFrame tenta retirar um frame completo (prefixo de 4 bytes +
+// envelope JSON) do início de buf, no mesmo formato que protocol.Decoder lê
+// de um io.Reader - aqui porém lendo de um buffer acumulado em memória, já
+// que o reator não tem um io.Reader bloqueante por conexão.
+func extractFrame(buf []byte) (frame, rest []byte, ok bool, err error) {
+	if len(buf) < 4 {
+		return nil, buf, false, nil
+	}
+	size := binary.BigEndian.Uint32(buf[:4])
+	if size > protocol.MaxFrameSize {
+		return nil, buf, false, fmt.Errorf("frame de %d bytes excede MaxFrameSize (%d)", size, protocol.MaxFrameSize)
+	}
+	if len(buf) < int(4+size) {
+		return nil, buf, false, nil
+	}
+	return buf[4 : 4+size], buf[4+size:], true, nil
+}
+
+// dispatchFrame decodifica o envelope de um frame já completo. Antes do
+// HELLO, só aceita TypeHello e registra o cliente (espelhando a primeira
+// metade de handleClient); depois, repassa tipo e payload a Server.dispatch
+// exatamente como o caminho goroutine-por-conexão faria.
+func (r *reactor) dispatchFrame(fc *fdConn, raw []byte) {
+	var env struct {
+		Type protocol.MessageType `json:"type"`
+		Data json.RawMessage      `json:"data"`
+	}
+	if err := json.Unmarshal(raw, &env); err != nil {
+		log.Printf("[REACTOR] envelope invalido de fd %d: %v", fc.fd, err)
+		r.closeConn(fc)
+		return
+	}
+
+	if !fc.registered {
+		r.handleHello(fc, env.Type, env.Data)
+		return
+	}
+
+	r.s.dispatch(fc.id, fc.cc, env.Type, env.Data)
+}
+
+// handleHello replica o handshake de handleClient para uma conexão do
+// reator: exige que o primeiro frame seja HELLO com um ID não vazio, registra
+// o clientConn (usando fc.writer como os três papéis de clientStreams, já
+// que o reator só suporta TransportRaw) e envia o WelcomeMsg.
+func (r *reactor) handleHello(fc *fdConn, msgType protocol.MessageType, data json.RawMessage) {
+	if msgType != protocol.TypeHello {
+		r.closeConn(fc)
+		return
+	}
+	var hello protocol.HelloMsg
+	if err := json.Unmarshal(data, &hello); err != nil || strings.TrimSpace(hello.ID) == "" {
+		r.closeConn(fc)
+		return
+	}
+	id := strings.TrimSpace(hello.ID)
+
+	mu := &sync.Mutex{}
+	streams := &clientStreams{
+		control: fc.writer, broadcast: fc.writer, event: fc.writer,
+		controlMu: mu, broadcastMu: mu, eventMu: mu,
+	}
+	cc := newClientConn(streams)
+
+	r.s.mu.Lock()
+	if _, exists := r.s.clients[id]; exists {
+		r.s.mu.Unlock()
+		if frame, err := protocol.EncodeFrame(protocol.TypeError, protocol.ErrorMsg{
+			Code: "NAME_TAKEN", Message: "nome em uso",
+		}); err == nil {
+			fc.writer.Write(frame)
+		}
+		r.closeConn(fc)
+		return
+	}
+	r.s.clients[id] = cc
+	r.s.mu.Unlock()
+
+	fc.registered = true
+	fc.id = id
+	fc.cc = cc
+	r.s.Metrics.ClientsConnected.Add(1)
+	log.Printf("[REACTOR] Conectado: %s", id)
+
+	if frame, err := protocol.EncodeFrame(protocol.TypeWelcome, protocol.WelcomeMsg{
+		Message: "Comandos: LIST | CREATE | JOIN | LEAVE | VOTE",
+	}); err == nil {
+		r.s.send(id, cc, frame)
+	}
+}
+
+// closeConn desregistra fd do epoll, remove seu estado e, se já tinha
+// completado o handshake, espelha a limpeza de fim de handleClient: some do
+// mapa de clientes, sai de todo poll em que estava inscrito e fecha suas
+// filas de saída. loop() e um worker() podem chamar closeConn para o mesmo
+// fdConn de goroutines diferentes (ver fdConn.closeOnce); o corpo só roda na
+// primeira chamada.
+func (r *reactor) closeConn(fc *fdConn) {
+	fc.closeOnce.Do(func() { r.closeConnOnce(fc) })
+}
+
+func (r *reactor) closeConnOnce(fc *fdConn) {
+	unix.EpollCtl(r.epfd, unix.EPOLL_CTL_DEL, fc.fd, nil)
+
+	r.connsMu.Lock()
+	delete(r.conns, fc.fd)
+	r.connsMu.Unlock()
+
+	if fc.registered {
+		r.s.mu.Lock()
+		delete(r.s.clients, fc.id)
+		r.s.mu.Unlock()
+
+		r.s.Metrics.ClientsDisconnected.Add(1)
+
+		for _, pollID := range fc.cc.joinedPolls() {
+			if poll := r.s.getPoll(pollID); poll != nil {
+				poll.mu.Lock()
+				delete(poll.subscribers, fc.id)
+				poll.mu.Unlock()
+			}
+		}
+		if r.s.mode == ModeFanout {
+			fc.cc.closeQueues()
+			fc.cc.closeOutboxes()
+		}
+		log.Printf("[REACTOR] Desconectado: %s", fc.id)
+	}
+
+	fc.raw.Close()
+	r.s.releaseSlot()
+}
+
+// close encerra o epoll deste reator; a próxima chamada a epoll_wait em loop
+// (no máximo 500ms depois) devolve erro (fd fechado), que por sua vez fecha r.jobs e deixa cada worker sair do seu
+// range. Server.Shutdown espera essas goroutines via s.wg antes de retornar.
+func (r *reactor) close() {
+	unix.Close(r.epfd)
+}
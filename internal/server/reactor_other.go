@@ -0,0 +1,14 @@
+//go:build !linux
+
+package server
+
+import "fmt"
+
+// newReactor é o stub usado fora do Linux: IOModelReactor depende de epoll
+// (ver reactor_linux.go), então aqui só devolvemos um erro claro em vez de
+// simular um segundo backend (kqueue, IOCP) que este pacote não implementa.
+// Start trata esse erro encerrando a inicialização, exatamente como faria
+// para qualquer outra falha de net.Listen.
+func newReactor(s *Server) (ioReactor, error) {
+	return nil, fmt.Errorf("server: IOModelReactor requer Linux (epoll); plataforma atual nao suportada")
+}
@@ -1,100 +1,418 @@
 package server
 
 import (
-	"bufio"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"log"
 	"net"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
-)
 
+	"github.com/yLukas077/tcp-vote/internal/cluster"
+	"github.com/yLukas077/tcp-vote/internal/protocol"
+)
 
 type VotingState string
 
 const (
-    VotingNotStarted VotingState = "NOT_STARTED"
-    VotingActive     VotingState = "ACTIVE"
-    VotingEnded      VotingState = "ENDED"
+	VotingNotStarted VotingState = "NOT_STARTED"
+	VotingActive     VotingState = "ACTIVE"
+	VotingEnded      VotingState = "ENDED"
 )
 
-// VotingOptions encapsula as opções de voto disponíveis.
-type VotingOptions struct {
-	List          []string
-	DisplayString string
+// BroadcastMode seleciona como o servidor entrega atualizações de placar aos clientes.
+type BroadcastMode int
+
+const (
+	// ModeSync segura poll.mu durante conn.Write (modo "lento" original).
+	// Um cliente lento bloqueia apenas o poll ao qual pertence.
+	ModeSync BroadcastMode = iota
+	// ModeAsync usa um channel + worker goroutine (modo "assíncrono" original).
+	// Libera o mutex de voto rapidamente, mas o worker ainda escreve direto.
+	ModeAsync
+	// ModeFanout dá a cada cliente uma fila de saída dedicada por poll e uma
+	// goroutine de escrita; nenhum broadcast segura um mutex durante I/O de rede.
+	ModeFanout
+)
+
+func (m BroadcastMode) String() string {
+	switch m {
+	case ModeSync:
+		return "SYNC"
+	case ModeAsync:
+		return "ASYNC"
+	case ModeFanout:
+		return "FANOUT"
+	default:
+		return "DESCONHECIDO"
+	}
+}
+
+const (
+	// clientSendQueueDepth é a profundidade de cada fila de saída por cliente
+	// no MODE_FANOUT, no mesmo espírito do perClientSendQueueDepth do DERP:
+	// pequena o suficiente para não acumular memória, grande o suficiente
+	// para absorver rajadas.
+	clientSendQueueDepth = 32
+
+	// maxQueueDrops é o número de frames descartados (fila cheia) tolerado antes
+	// de encerrarmos a conexão de um cliente persistentemente lento.
+	maxQueueDrops = 10
+
+	// controlQueueKey identifica a fila de saída usada para respostas diretas
+	// (boas-vindas, confirmações, erros) que não pertencem a um poll específico.
+	controlQueueKey = "_control"
+
+	// defaultWriteTimeout é o prazo padrão de Server.WriteTimeout, usado em
+	// todo conn.Write no caminho de broadcast.
+	defaultWriteTimeout = 2 * time.Second
+	// defaultRegistrationTimeout é o prazo padrão de Server.RegistrationTimeout.
+	defaultRegistrationTimeout = 10 * time.Second
+	// defaultKeepAlivePeriod é o prazo padrão de Server.KeepAlivePeriod.
+	defaultKeepAlivePeriod = 30 * time.Second
+	// defaultAdmissionTimeout é o prazo padrão de Server.AdmissionTimeout,
+	// usado quando AdmissionPolicy é AdmissionWait.
+	defaultAdmissionTimeout = 5 * time.Second
+	// defaultSlowClientDeadline é o prazo padrão de Server.SlowClientDeadline.
+	defaultSlowClientDeadline = 5 * time.Second
+)
+
+// AdmissionPolicy decide o que fazer quando uma nova conexão chega com
+// Server.MaxClients já no limite.
+type AdmissionPolicy int
+
+const (
+	// AdmissionRefuse recusa a conexão imediatamente com ErrorMsg{Code:"BUSY"}.
+	AdmissionRefuse AdmissionPolicy = iota
+	// AdmissionWait espera até AdmissionTimeout por uma vaga antes de recusar.
+	AdmissionWait
+)
+
+func (p AdmissionPolicy) String() string {
+	switch p {
+	case AdmissionRefuse:
+		return "REFUSE"
+	case AdmissionWait:
+		return "WAIT"
+	default:
+		return "DESCONHECIDO"
+	}
+}
+
+// clientConn agrega a conexão TCP de um cliente com suas filas de saída.
+// Em MODE_FANOUT cada chave de fila (controlQueueKey ou um pollID) tem sua
+// própria goroutine de envio, então um poll muito ativo nunca esgota a fila
+// de outro poll ao qual o mesmo cliente está inscrito.
+type clientConn struct {
+	streams *clientStreams
+
+	// peerCert é o certificado de folha do cliente quando esta conexão foi
+	// autenticada via mTLS (Server.TLSConfig != nil); nil caso contrário.
+	// Guardado aqui para que processVote/processVoteClustered consultem
+	// Server.CertRevocation sem precisar replicar o handshake.
+	peerCert *x509.Certificate
+
+	queuesMu     sync.Mutex
+	queues       map[string]chan []byte
+	queuesClosed bool // setado sob queuesMu por closeQueues, ver ensureQueue
+
+	// outboxes guarda, por pollID, o ring buffer coalescente de placar deste
+	// cliente em MODE_FANOUT (ver outbox.go); as filas de controle e eventos
+	// continuam em queues, que não coalescem.
+	outboxesMu     sync.Mutex
+	outboxes       map[string]*clientOutbox
+	outboxesClosed bool // setado sob outboxesMu por closeOutboxes, ver ensureOutbox
+
+	dropped int64 // atômico: frames descartados por fila cheia (somado entre filas)
+
+	joinedMu sync.Mutex
+	joined   map[string]struct{} // polls em que este cliente está inscrito (JOIN/LEAVE)
+
+	closeOnce         sync.Once
+	queuesCloseOnce   sync.Once
+	outboxesCloseOnce sync.Once
+	done              chan struct{}
 }
 
-// Server representa o servidor TCP de votação concorrente.
+func newClientConn(streams *clientStreams) *clientConn {
+	return &clientConn{
+		streams:  streams,
+		queues:   make(map[string]chan []byte),
+		outboxes: make(map[string]*clientOutbox),
+		joined:   make(map[string]struct{}),
+		done:     make(chan struct{}),
+	}
+}
+
+// close encerra a conexão e sinaliza handleClient, de forma idempotente.
+func (cc *clientConn) close() {
+	cc.closeOnce.Do(func() {
+		close(cc.done)
+		cc.streams.close()
+	})
+}
+
+// closeQueues fecha todas as filas de saída do cliente exatamente uma vez,
+// mesmo se chamada tanto pela desconexão normal quanto por Server.Shutdown
+// concorrentemente. Cada pollSenderLoop drena o que sobrou e retorna.
+// queuesClosed é setado sob o mesmo queuesMu usado por ensureQueue para
+// criar filas e enfileirar mensagens - sem isso, um StartVoting/endVoting
+// atrasado (timer de fim de votação ou broadcast de voto expirado) poderia
+// recriar ou reencontrar uma fila já fechada e sofrer panic ("send on
+// closed channel") ao tentar enfileirar nela.
+func (cc *clientConn) closeQueues() {
+	cc.queuesCloseOnce.Do(func() {
+		cc.queuesMu.Lock()
+		cc.queuesClosed = true
+		for _, q := range cc.queues {
+			close(q)
+		}
+		cc.queuesMu.Unlock()
+	})
+}
+
+// closeOutboxes fecha todo outbox de placar do cliente exatamente uma vez,
+// mesmo se chamada tanto pela desconexão normal quanto por Server.Shutdown
+// concorrentemente. Cada pollOutboxLoop esvazia o que sobrou e retorna.
+// outboxesClosed evita que ensureOutbox crie um outbox novo (e sua
+// pollOutboxLoop) depois disso: Push de um outbox já existente já se
+// protege sozinho (ver clientOutbox.closed em outbox.go), mas um outbox
+// criado após closeOutboxes nunca seria fechado por ninguém, vazando sua
+// goroutine de envio para sempre bloqueada em Pop.
+func (cc *clientConn) closeOutboxes() {
+	cc.outboxesCloseOnce.Do(func() {
+		cc.outboxesMu.Lock()
+		cc.outboxesClosed = true
+		for _, ob := range cc.outboxes {
+			ob.Close()
+		}
+		cc.outboxesMu.Unlock()
+	})
+}
+
+func (cc *clientConn) trackJoin(pollID string) {
+	cc.joinedMu.Lock()
+	cc.joined[pollID] = struct{}{}
+	cc.joinedMu.Unlock()
+}
+
+func (cc *clientConn) trackLeave(pollID string) {
+	cc.joinedMu.Lock()
+	delete(cc.joined, pollID)
+	cc.joinedMu.Unlock()
+}
+
+// joinedPolls retorna um snapshot dos polls em que o cliente está inscrito.
+func (cc *clientConn) joinedPolls() []string {
+	cc.joinedMu.Lock()
+	defer cc.joinedMu.Unlock()
+	out := make([]string, 0, len(cc.joined))
+	for id := range cc.joined {
+		out = append(out, id)
+	}
+	return out
+}
+
+// Server representa o servidor TCP de votação concorrente, hoje capaz de
+// hospedar múltiplos polls independentes simultaneamente.
 type Server struct {
-    // SYSCALL: socket(AF_INET, SOCK_STREAM, 0) + bind() + listen()
-    // listener é o socket em estado LISTEN aguardando SYN packets
-    // Internamente, o kernel usa um file descriptor (FD) para rastrear este socket
-    listener net.Listener
-
-	// Mutex protege acesso concorrente aos mapas compartilhados
-	// Previne race conditions em leituras/escritas simultâneas
-	mu         sync.Mutex
-	clients    map[string]net.Conn // Mapa de file descriptors ativos (ID -> conexão TCP)
-	votes      map[string]string   // Histórico de votos
-	voteCounts map[string]int      // Placar agregado
-
-	options           VotingOptions
-	useAsyncBroadcast bool
-
-	// Channel para comunicação assíncrona entre goroutines
+	// SYSCALL: socket(AF_INET, SOCK_STREAM, 0) + bind() + listen()
+	// listener é o socket em estado LISTEN aguardando SYN packets
+	// Internamente, o kernel usa um file descriptor (FD) para rastrear este socket
+	listener net.Listener
+
+	// Mutex protege acesso concorrente ao mapa de clientes
+	mu      sync.Mutex
+	clients map[string]*clientConn // Mapa de clientes ativos (ID -> conexão + filas de envio)
+
+	// pollsMu protege o mapa de polls em si (criação/remoção/listagem); o
+	// estado interno de cada Poll é protegido pelo seu próprio mutex.
+	pollsMu sync.RWMutex
+	polls   map[string]*Poll
+
+	mode BroadcastMode
+
+	// Transport seleciona como os fluxos lógicos do protocolo (controle,
+	// placar, eventos) são transportados sobre a conexão TCP de cada
+	// cliente. Zero (padrão) é TransportRaw; ver TransportMux para isolar
+	// cada fluxo num stream yamux independente.
+	Transport TransportMode
+
+	// IOModel seleciona como Start multiplexa a I/O das conexões aceitas.
+	// Zero (padrão) é IOModelGoroutine; ver IOModelReactor para um loop de
+	// eventos baseado em epoll, dimensionado para fan-out muito grande.
+	// IOModelReactor só suporta TransportRaw (ver reactor_linux.go) e só
+	// está disponível em Linux.
+	IOModel IOModel
+	// reactor guarda o loop de epoll quando IOModel é IOModelReactor; nil
+	// caso contrário.
+	reactor ioReactor
+
+	// WriteTimeout limita quanto tempo um conn.Write pode demorar antes de
+	// derrubarmos o cliente. Zero em NewServer vira defaultWriteTimeout.
+	WriteTimeout time.Duration
+	// RegistrationTimeout limita quanto tempo esperamos pelo frame HELLO no
+	// handshake; sem ele uma conexão half-open prenderia a goroutine para sempre.
+	RegistrationTimeout time.Duration
+	// KeepAlivePeriod configura o TCP keepalive de cada conexão aceita.
+	KeepAlivePeriod time.Duration
+
+	// MaxClients limita quantas conexões o servidor atende simultaneamente,
+	// via um semáforo de contagem (o mesmo padrão usado para limitar workers
+	// concorrentes no exemplo do crawler). Zero (padrão) significa sem limite.
+	MaxClients int
+	// AdmissionPolicy decide o que fazer quando MaxClients já está no limite.
+	AdmissionPolicy AdmissionPolicy
+	// AdmissionTimeout é por quanto tempo esperar por uma vaga quando
+	// AdmissionPolicy é AdmissionWait.
+	AdmissionTimeout time.Duration
+	// sem é o semáforo de admissão; nil quando MaxClients <= 0 (sem limite).
+	sem chan struct{}
+
+	// SlowClientDeadline é por quanto tempo o outbox de placar (ver
+	// outbox.go) de um cliente pode ficar continuamente cheio antes de
+	// encerrarmos a conexão - sinal de que o cliente parou de drenar o
+	// placar, não só de uma rajada passageira. Zero desativa essa checagem
+	// (o outbox segue coalescendo indefinidamente, sem nunca desconectar por
+	// isso).
+	SlowClientDeadline time.Duration
+
+	// Metrics expõe contadores expvar; ver StartMetrics para o sidecar HTTP.
+	Metrics *Metrics
+
+	// TLSConfig, se não nil, faz Start escutar com tls.Listen em vez de
+	// net.Listen - ver LoadMutualTLS para montar um *tls.Config exigindo
+	// certificado de cliente (mTLS). Incompatível com IOModelReactor: o
+	// reator precisa do fd bruto da conexão (SyscallConn), que um *tls.Conn
+	// não expõe da mesma forma; Start recusa essa combinação.
+	TLSConfig *tls.Config
+
+	// CertRevocation, se não nil, é consultado em processVote/
+	// processVoteClustered para cada voto de um cliente autenticado por
+	// mTLS, usando o certificado de folha guardado em clientConn.peerCert.
+	// Permite revogar um eleitor antes do certificado expirar (ex.: uma CRL
+	// carregada separadamente), independente da validação de cadeia já
+	// feita pelo handshake TLS. nil desativa a checagem.
+	CertRevocation func(*x509.Certificate) error
+
+	// Cluster, se não nil, substitui o armazenamento puramente local de
+	// votos/estado/prazo de cada poll por um log replicado via Raft (ver
+	// internal/cluster): só o líder do cluster aceita START_VOTING/VOTE/
+	// END_VOTING; os demais nós recusam com ErrorMsg{Code:"NAO_LIDER"}
+	// apontando Cluster.LeaderAddr(). nil (padrão) preserva o comportamento
+	// de nó único original. Ver NewClusteredServer.
+	Cluster *cluster.Cluster
+
+	// Channel para comunicação assíncrona entre goroutines (usado em ModeAsync)
 	// Buffer de 1000 previne bloqueio em picos de carga
-	broadcastChan chan map[string]int
+	broadcastChan chan pollUpdate
 
-	// Controle de votação
-    votingState    VotingState
-    votingDeadline time.Time
+	// wg rastreia toda goroutine de longa duração (handleClient, pollSenderLoop,
+	// broadcastWorker) para que Shutdown saiba quando não resta mais trabalho.
+	wg           sync.WaitGroup
+	shutdownOnce sync.Once
 }
 
-// NewServer inicializa o servidor com opções de voto e modo de operação.
-func NewServer(async bool, optionsList []string) *Server {
-	s := &Server{
-		clients:    make(map[string]net.Conn),
-		votes:      make(map[string]string),
-		voteCounts: make(map[string]int),
-		options: VotingOptions{
-			List:          optionsList,
-			DisplayString: strings.Join(optionsList, ", "),
-		},
-		useAsyncBroadcast: async,
-		votingState:       VotingNotStarted,
-	}
+// pollUpdate é o que trafega em Server.broadcastChan (ModeAsync): o placar de
+// um poll específico, já copiado para fora da seção crítica do Poll.
+type pollUpdate struct {
+	pollID string
+	counts map[string]int
+	seq    uint64
+}
 
-	// Inicializa contadores para todas as opções
-	for _, op := range optionsList {
-		s.voteCounts[op] = 0
+// NewServer inicializa o servidor vazio (sem polls) no modo de broadcast
+// informado. Use CreatePoll para abrir votações.
+func NewServer(mode BroadcastMode) *Server {
+	s := &Server{
+		clients:             make(map[string]*clientConn),
+		polls:               make(map[string]*Poll),
+		mode:                mode,
+		WriteTimeout:        defaultWriteTimeout,
+		RegistrationTimeout: defaultRegistrationTimeout,
+		KeepAlivePeriod:     defaultKeepAlivePeriod,
+		AdmissionTimeout:    defaultAdmissionTimeout,
+		SlowClientDeadline:  defaultSlowClientDeadline,
 	}
 
-	if async {
-		s.broadcastChan = make(chan map[string]int, 1000)
+	if mode == ModeAsync {
+		s.broadcastChan = make(chan pollUpdate, 1000)
 		// Goroutine worker consome canal em background
+		s.wg.Add(1)
 		go s.broadcastWorker()
 	}
 
+	s.Metrics = newMetrics(s)
+
 	return s
 }
 
-// Start inicia o servidor TCP na porta especificada.
+// NewClusteredServer é como NewServer, mas liga o Server resultante a um nó
+// de um cluster de votação replicado via Raft (ver cluster.NewCluster):
+// votos e transições de VotingState só são aceitos no líder e só são
+// repassados aos clientes (via s.Cluster.FSM().OnApply, ligado aqui a
+// s.onClusterApply) depois de commitados pela maioria do cluster.
+func NewClusteredServer(mode BroadcastMode, cfg cluster.ClusterConfig) (*Server, error) {
+	c, err := cluster.NewCluster(cfg)
+	if err != nil {
+		return nil, fmt.Errorf("server: erro ao iniciar cluster: %w", err)
+	}
+
+	s := NewServer(mode)
+	s.Cluster = c
+	c.FSM().OnApply = s.onClusterApply
+	return s, nil
+}
+
+// Start inicia o servidor TCP na porta especificada. Se TLSConfig não for
+// nil, escuta com TLS (mTLS quando TLSConfig exige certificado de cliente,
+// ver LoadMutualTLS) em vez de texto plano.
 func (s *Server) Start(port string) {
 	var err error
 
+	if s.TLSConfig != nil && s.IOModel == IOModelReactor {
+		log.Fatalf("Erro ao iniciar: TLSConfig nao e suportado com IOModelReactor (o reator precisa do fd bruto da conexao)")
+	}
+
 	// SYSCALL: socket() cria file descriptor
 	// SYSCALL: bind() associa fd à porta 9000
 	// SYSCALL: listen() marca socket como passivo, aceita SYN packets
 	// Kernel mantém duas filas:
 	//   - SYN queue: conexões half-open (aguardando ACK)
 	//   - Accept queue: conexões completas prontas para Accept()
-	s.listener, err = net.Listen("tcp", port)
+	if s.TLSConfig != nil {
+		s.listener, err = tls.Listen("tcp", port, s.TLSConfig)
+	} else {
+		s.listener, err = net.Listen("tcp", port)
+	}
 	if err != nil {
 		log.Fatalf("Erro ao iniciar: %v", err)
 	}
 	log.Printf("Servidor ouvindo na porta %s", port)
-	log.Printf("Opções de voto: [%s]", s.options.DisplayString)
+	log.Printf("Modo de broadcast: %s", s.mode)
+	if s.TLSConfig != nil {
+		log.Printf("TLS habilitado (mTLS: %v)", s.TLSConfig.ClientAuth == tls.RequireAndVerifyClientCert)
+	}
+
+	if s.MaxClients > 0 {
+		s.sem = make(chan struct{}, s.MaxClients)
+		log.Printf("Admissão limitada a %d clientes (politica: %s)", s.MaxClients, s.AdmissionPolicy)
+	}
+
+	log.Printf("Modelo de IO: %s", s.IOModel)
+	if s.IOModel == IOModelReactor {
+		r, err := newReactor(s)
+		if err != nil {
+			log.Fatalf("Erro ao iniciar reactor: %v", err)
+		}
+		s.reactor = r
+	}
 
 	// Event loop principal
 	for {
@@ -104,127 +422,403 @@ func (s *Server) Start(port string) {
 		// net.Conn é o wrapper Go deste socket TCP
 		conn, err := s.listener.Accept()
 		if err != nil {
+			// listener fechado por Shutdown: encerra o loop sem logar ruído.
+			if errors.Is(err, net.ErrClosed) {
+				return
+			}
 			log.Println("Erro no accept:", err)
 			continue
 		}
 
+		if !s.acquireSlot(conn) {
+			// Recusada por falta de vaga; acquireSlot já respondeu e fechou.
+			continue
+		}
+
+		if s.reactor != nil {
+			if err := s.reactor.register(conn); err != nil {
+				log.Printf("[REACTOR] erro ao registrar conexão: %v", err)
+				s.releaseSlot()
+				conn.Close()
+			}
+			continue
+		}
+
 		// Goroutine separada para cada cliente (modelo M:N do Go)
 		// Goroutines são multiplexadas em threads do SO pelo runtime
+		s.wg.Add(1)
 		go s.handleClient(conn)
 	}
 }
 
-// StartVoting inicia a votação com tempo limite em segundos
-func (s *Server) StartVoting(durationSeconds int) {
-    s.mu.Lock()
-    defer s.mu.Unlock()
-    
-    if s.votingState != VotingNotStarted {
-        log.Println("Votação já foi iniciada anteriormente")
-        return
-    }
-    
-    s.votingState = VotingActive
-    s.votingDeadline = time.Now().Add(time.Duration(durationSeconds) * time.Second)
-    
-    log.Printf("Votação INICIADA. Deadline: %s", s.votingDeadline.Format("15:04:05"))
-    
-    // Notifica todos os clientes
-    announcement := fmt.Sprintf("VOTACAO_INICIADA: %d segundos. Opcoes: [%s]\n", 
-        durationSeconds, s.options.DisplayString)
-    
-    for _, conn := range s.clients {
-        conn.Write([]byte(announcement))
-    }
-    
-    // Timer para encerrar automaticamente
-    time.AfterFunc(time.Duration(durationSeconds)*time.Second, func() {
-        s.endVoting()
-    })
-}
-
-// endVoting encerra a votação e envia resultado final
-func (s *Server) endVoting() {
-    s.mu.Lock()
-    defer s.mu.Unlock()
-    
-    if s.votingState != VotingActive {
-        return
-    }
-    
-    s.votingState = VotingEnded
-    log.Println("Votação ENCERRADA")
-    
-    // Resultado final
-    result := fmt.Sprintf("VOTACAO_ENCERRADA: %v\n", s.voteCounts)
-    
-    for _, conn := range s.clients {
-        conn.Write([]byte(result))
-    }
+// acquireSlot aplica o controle de admissão: adquire uma vaga no semáforo
+// s.sem antes de a conexão virar uma goroutine handleClient. Sem vaga
+// disponível, recusa conforme s.AdmissionPolicy - de imediato (AdmissionRefuse)
+// ou após esperar até s.AdmissionTimeout (AdmissionWait). Retorna false se a
+// conexão foi recusada (e já fechada pelo próprio acquireSlot).
+func (s *Server) acquireSlot(conn net.Conn) bool {
+	if s.sem == nil {
+		return true
+	}
+
+	select {
+	case s.sem <- struct{}{}:
+		return true
+	default:
+	}
+
+	if s.AdmissionPolicy == AdmissionWait {
+		timer := time.NewTimer(s.AdmissionTimeout)
+		defer timer.Stop()
+		select {
+		case s.sem <- struct{}{}:
+			return true
+		case <-timer.C:
+		}
+	}
+
+	s.Metrics.ConnectionsRefusedBusy.Add(1)
+	log.Printf("Conexão recusada: limite de %d clientes atingido", s.MaxClients)
+	if frame, err := protocol.EncodeFrame(protocol.TypeError, protocol.ErrorMsg{
+		Code: "BUSY", Message: "servidor no limite de conexoes, tente novamente mais tarde",
+	}); err == nil {
+		conn.SetWriteDeadline(time.Now().Add(s.WriteTimeout))
+		conn.Write(frame)
+	}
+	conn.Close()
+	return false
+}
+
+// releaseSlot devolve a vaga adquirida por acquireSlot, se houver semáforo.
+func (s *Server) releaseSlot() {
+	if s.sem != nil {
+		<-s.sem
+	}
+}
+
+// Shutdown encerra o servidor de forma graciosa: para de aceitar novas
+// conexões, avisa cada cliente conectado com um frame SHUTDOWN, fecha as
+// filas de envio de cada cliente (cada pollSenderLoop drena o que sobrou e
+// retorna), fecha broadcastChan (broadcastWorker sai via range) e espera
+// toda goroutine rastreada em s.wg terminar, respeitando ctx como prazo
+// máximo. É seguro chamar mais de uma vez; só a primeira tem efeito.
+func (s *Server) Shutdown(ctx context.Context) error {
+	s.shutdownOnce.Do(func() {
+		if s.listener != nil {
+			s.listener.Close()
+		}
+
+		s.mu.Lock()
+		clients := make([]*clientConn, 0, len(s.clients))
+		for _, cc := range s.clients {
+			clients = append(clients, cc)
+		}
+		s.mu.Unlock()
+
+		shutdownFrame, err := protocol.EncodeFrame(protocol.TypeShutdown, protocol.ShutdownMsg{
+			Message: "servidor encerrando",
+		})
+		if err != nil {
+			log.Printf("Shutdown: erro ao codificar aviso: %v", err)
+		} else {
+			for _, cc := range clients {
+				cc.streams.eventMu.Lock()
+				s.writeConn(cc.streams.event, shutdownFrame)
+				cc.streams.eventMu.Unlock()
+			}
+		}
+
+		for _, cc := range clients {
+			cc.closeQueues()
+			cc.closeOutboxes()
+			cc.close()
+		}
+
+		if s.broadcastChan != nil {
+			close(s.broadcastChan)
+		}
+
+		if s.Cluster != nil {
+			if err := s.Cluster.Shutdown(); err != nil {
+				log.Printf("Shutdown: erro ao encerrar cluster: %v", err)
+			}
+		}
+
+		if s.reactor != nil {
+			s.reactor.close()
+		}
+	})
+
+	done := make(chan struct{})
+	go func() {
+		s.wg.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// CreatePoll registra um novo poll com as opções informadas. Retorna erro se
+// já existir um poll com o mesmo ID (mensagem CreateMsg).
+func (s *Server) CreatePoll(id string, optionsList []string) error {
+	s.pollsMu.Lock()
+	defer s.pollsMu.Unlock()
+
+	if _, exists := s.polls[id]; exists {
+		return fmt.Errorf("poll '%s' ja existe", id)
+	}
+	s.polls[id] = newPoll(id, optionsList)
+	log.Printf("Poll criado: %s (opcoes: %s)", id, strings.Join(optionsList, ", "))
+	return nil
+}
+
+// getPoll busca um poll pelo ID; retorna nil se não existir.
+func (s *Server) getPoll(id string) *Poll {
+	s.pollsMu.RLock()
+	defer s.pollsMu.RUnlock()
+	return s.polls[id]
+}
+
+// getClient busca a conexão registrada de um cliente pelo ID.
+func (s *Server) getClient(id string) *clientConn {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.clients[id]
+}
+
+// StartVoting inicia a votação de um poll com tempo limite em segundos. Em
+// cluster, a mutação só é aceita no líder e o anúncio aos inscritos é
+// disparado por onClusterApply assim que ela replica, não por esta função.
+func (s *Server) StartVoting(pollID string, durationSeconds int) error {
+	poll := s.getPoll(pollID)
+	if poll == nil {
+		return fmt.Errorf("poll '%s' nao existe", pollID)
+	}
+
+	if s.Cluster != nil {
+		_, err := s.Cluster.Apply(cluster.Command{
+			Type: cluster.CmdStartVoting, PollID: pollID, Duration: durationSeconds,
+		})
+		if errors.Is(err, cluster.ErrNotLeader) {
+			log.Printf("StartVoting '%s': este no nao e o lider (lider atual: %s)", pollID, s.Cluster.LeaderAddr())
+			return nil
+		}
+		return err
+	}
+
+	poll.mu.Lock()
+	if poll.state != VotingNotStarted {
+		poll.mu.Unlock()
+		log.Printf("Poll '%s' já foi iniciado anteriormente", pollID)
+		return nil
+	}
+	poll.state = VotingActive
+	poll.deadline = time.Now().Add(time.Duration(durationSeconds) * time.Second)
+	log.Printf("Poll '%s' INICIADO. Deadline: %s", pollID, poll.deadline.Format("15:04:05"))
+
+	announcement, err := protocol.EncodeFrame(protocol.TypePollEvent, protocol.PollEventMsg{
+		PollID: pollID,
+		Event:  "STARTED",
+		Message: fmt.Sprintf("Votacao iniciada: %d segundos. Opcoes: [%s]",
+			durationSeconds, poll.Options.DisplayString),
+	})
+	subs := make([]string, 0, len(poll.subscribers))
+	for subID := range poll.subscribers {
+		subs = append(subs, subID)
+	}
+	poll.mu.Unlock()
+
+	if err != nil {
+		log.Printf("StartVoting: erro ao codificar anúncio do poll '%s': %v", pollID, err)
+	} else {
+		for _, subID := range subs {
+			if cc := s.getClient(subID); cc != nil {
+				s.sendEvent(subID, cc, pollID, announcement)
+			}
+		}
+	}
+
+	// Timer para encerrar automaticamente
+	time.AfterFunc(time.Duration(durationSeconds)*time.Second, func() {
+		s.endVoting(pollID)
+	})
+	return nil
+}
+
+// endVoting encerra a votação de um poll e envia seu resultado final. Em
+// cluster, a mutação só é aceita no líder e o anúncio é disparado por
+// onClusterApply, não por esta função.
+func (s *Server) endVoting(pollID string) {
+	poll := s.getPoll(pollID)
+	if poll == nil {
+		return
+	}
+
+	if s.Cluster != nil {
+		if _, err := s.Cluster.Apply(cluster.Command{Type: cluster.CmdEndVoting, PollID: pollID}); err != nil {
+			log.Printf("endVoting '%s': %v", pollID, err)
+		}
+		return
+	}
+
+	poll.mu.Lock()
+	if poll.state != VotingActive {
+		poll.mu.Unlock()
+		return
+	}
+	poll.state = VotingEnded
+	log.Printf("Poll '%s' ENCERRADO", pollID)
+
+	result, err := protocol.EncodeFrame(protocol.TypePollEvent, protocol.PollEventMsg{
+		PollID:  pollID,
+		Event:   "ENDED",
+		Message: fmt.Sprintf("Votacao encerrada. Resultado: %v", poll.voteCounts),
+	})
+	subs := make([]string, 0, len(poll.subscribers))
+	for subID := range poll.subscribers {
+		subs = append(subs, subID)
+	}
+	poll.mu.Unlock()
+
+	if err != nil {
+		log.Printf("endVoting: erro ao codificar resultado do poll '%s': %v", pollID, err)
+		return
+	}
+	for _, subID := range subs {
+		if cc := s.getClient(subID); cc != nil {
+			s.sendEvent(subID, cc, pollID, result)
+		}
+	}
 }
 
 // handleClient processa um cliente conectado em goroutine dedicada.
 func (s *Server) handleClient(conn net.Conn) {
+	defer s.wg.Done()
+	defer s.releaseSlot()
 	// SYSCALL: close(fd) ao sair (libera file descriptor no kernel)
 	defer conn.Close()
 
-	// bufio.Reader mantém buffer interno de 4KB
-	// Reduz syscalls: ao invés de read(fd, buf, 1) para cada byte,
-	// faz read(fd, internal_buffer, 4096) e serve da memória
-	reader := bufio.NewReader(conn)
+	// rawConn é a conexão TCP de fato, mesmo quando conn a envolve (ex.:
+	// *tls.Conn.NetConn()) - usada para keepalive, que não existe na
+	// interface net.Conn genérica.
+	rawConn := conn
+	if tlsConn, ok := conn.(*tls.Conn); ok {
+		rawConn = tlsConn.NetConn()
+	}
+	if tcpConn, ok := rawConn.(*net.TCPConn); ok {
+		// Detecta peers mortos (crash, cabo desconectado) que nunca enviam FIN,
+		// para não deixar o socket e sua goroutine presos indefinidamente.
+		tcpConn.SetKeepAlive(true)
+		tcpConn.SetKeepAlivePeriod(s.KeepAlivePeriod)
+	}
+
+	// Em TransportMux, abre a sessão yamux e aceita os três streams lógicos
+	// do cliente (controle, placar, eventos) antes de qualquer leitura; em
+	// TransportRaw os três streams retornados são o próprio conn. O prazo de
+	// registro cobre também esse handshake: um cliente que completa o
+	// handshake yamux mas nunca abre os três streams esperados ficaria preso
+	// em session.Accept() para sempre, prendendo esta goroutine e sua vaga
+	// de admissão (s.sem) indefinidamente - exatamente o esgotamento de
+	// recursos que o prazo abaixo já evita para o HELLO. SetDeadline (não só
+	// leitura) porque o handshake TLS abaixo também escreve: um cliente que
+	// para de ler o ServerHello prenderia o Write() do handshake do mesmo
+	// jeito sem um prazo de escrita.
+	conn.SetDeadline(time.Now().Add(s.RegistrationTimeout))
+
+	// Quando TLSConfig exige certificado de cliente, o Handshake só roda de
+	// fato no primeiro Read/Write - força-o aqui, ainda sob o prazo de
+	// registro acima, para capturar o certificado de folha do cliente antes
+	// de abrir qualquer stream. peerCert fica nil em conexões sem TLS.
+	var peerCert *x509.Certificate
+	if tlsConn, ok := conn.(*tls.Conn); ok {
+		if err := tlsConn.Handshake(); err != nil {
+			log.Printf("Erro no handshake TLS: %v", err)
+			return
+		}
+		if certs := tlsConn.ConnectionState().PeerCertificates; len(certs) > 0 {
+			peerCert = certs[0]
+		}
+	}
+
+	streams, err := openServerStreams(conn, s.Transport)
+	if err != nil {
+		log.Printf("Erro ao abrir streams (%s): %v", s.Transport, err)
+		return
+	}
+	defer streams.close()
+	conn.SetDeadline(time.Time{})
+
+	dec := protocol.NewDecoder(streams.control)
+
+	// Prazo para o handshake: uma conexão que nunca manda seu HELLO (half-open
+	// ou cliente mal comportado) não pode prender esta goroutine para sempre.
+	streams.control.SetReadDeadline(time.Now().Add(s.RegistrationTimeout))
 
 	// SYSCALL: read(fd, buffer, size) - bloqueante se não há dados
 	// Lê do socket TCP (internamente usando o FD do kernel)
-	idStr, err := reader.ReadString('\n')
-	if err != nil {
+	msgType, data, err := dec.Decode()
+	if err != nil || msgType != protocol.TypeHello {
 		return
 	}
-	id := strings.TrimSpace(idStr)
+	var hello protocol.HelloMsg
+	if err := json.Unmarshal(data, &hello); err != nil || strings.TrimSpace(hello.ID) == "" {
+		return
+	}
+	streams.control.SetReadDeadline(time.Time{}) // registrado: volta a ler sem prazo
+	id := strings.TrimSpace(hello.ID)
+
+	// Em mTLS, a identidade do cliente vem do CommonName do certificado, não
+	// do HELLO - este ainda é exigido para manter o fluxo do protocolo, mas
+	// um cliente autenticado não pode se passar por outro alegando um ID
+	// diferente do que seu certificado prova.
+	if peerCert != nil {
+		id = strings.TrimSpace(peerCert.Subject.CommonName)
+		if id == "" {
+			return
+		}
+	}
+
+	cc := newClientConn(streams)
+	cc.peerCert = peerCert
 
 	// Seção crítica: protege acesso ao mapa compartilhado
 	s.mu.Lock()
 	if _, exists := s.clients[id]; exists {
 		s.mu.Unlock()
-		conn.Write([]byte("ERRO: NOME em uso\n"))
+		if frame, err := protocol.EncodeFrame(protocol.TypeError, protocol.ErrorMsg{
+			Code: "NAME_TAKEN", Message: "nome em uso",
+		}); err == nil {
+			streams.control.Write(frame)
+		}
 		return
 	}
-	s.clients[id] = conn
-
-	// Envia status da votação
-    var statusMsg string
-    switch s.votingState {
-    case VotingNotStarted:
-        statusMsg = "Aguardando inicio da votacao...\n"
-    case VotingActive:
-        remaining := time.Until(s.votingDeadline).Round(time.Second)
-        statusMsg = fmt.Sprintf("Votacao em andamento! Tempo restante: %s\nOpcoes: [%s]\n", 
-            remaining, s.options.DisplayString)
-    case VotingEnded:
-        statusMsg = fmt.Sprintf("Votacao encerrada. Resultado: %v\n", s.voteCounts)
-    }
-
+	s.clients[id] = cc
 	s.mu.Unlock()
 
+	s.Metrics.ClientsConnected.Add(1)
 	log.Printf("Conectado: %s", id)
 
-	welcomeMsg := fmt.Sprintf("Bem-vindo! Opcoes disponiveis: [%s]. Digite: VOTE [Opcao]\n", s.options.DisplayString)
-	
 	// SYSCALL: write(fd, buffer, len)
 	// Escreve no TCP send buffer do kernel
 	// Kernel fragmenta em segmentos TCP (MSS ~1460 bytes) e envia
-	conn.Write([]byte(welcomeMsg))
+	if frame, err := protocol.EncodeFrame(protocol.TypeWelcome, protocol.WelcomeMsg{
+		Message: "Comandos: LIST | CREATE | JOIN | LEAVE | VOTE",
+	}); err == nil {
+		s.send(id, cc, frame)
+	}
 
 	// Loop de leitura de comandos
 	for {
-		msg, err := reader.ReadString('\n')
+		msgType, data, err := dec.Decode()
 		if err != nil {
 			break
 		}
-
-		msg = strings.TrimSpace(msg)
-		if strings.HasPrefix(msg, "VOTE ") {
-			s.processVote(id, strings.TrimPrefix(msg, "VOTE "))
-		}
+		s.dispatch(id, cc, msgType, data)
 	}
 
 	// Cleanup: remove cliente desconectado
@@ -232,152 +826,720 @@ func (s *Server) handleClient(conn net.Conn) {
 	delete(s.clients, id)
 	s.mu.Unlock()
 
+	s.Metrics.ClientsDisconnected.Add(1)
+
+	// Sai de todos os polls em que estava inscrito, para não deixar entradas
+	// órfãs em Poll.subscribers.
+	for _, pollID := range cc.joinedPolls() {
+		if poll := s.getPoll(pollID); poll != nil {
+			poll.mu.Lock()
+			delete(poll.subscribers, id)
+			poll.mu.Unlock()
+		}
+	}
+
+	if s.mode == ModeFanout {
+		// Nenhum s.send(id, ...) pode mais encontrar cc (já removido do mapa
+		// sob s.mu), então fechar as filas aqui é seguro.
+		cc.closeQueues()
+		cc.closeOutboxes()
+	}
+
 	log.Printf("Desconectado: %s", id)
 }
 
-// processVote processa um voto e dispara broadcast.
-func (s *Server) processVote(id, option string) {
-	s.mu.Lock()
-	defer s.mu.Unlock()
+// dispatch decodifica o payload de um frame já identificado por Decode e o
+// encaminha ao handler correspondente do protocolo.
+func (s *Server) dispatch(id string, cc *clientConn, msgType protocol.MessageType, data json.RawMessage) {
+	switch msgType {
+	case protocol.TypeList:
+		s.handleListCmd(id, cc)
+	case protocol.TypeCreate:
+		var msg protocol.CreateMsg
+		if err := json.Unmarshal(data, &msg); err != nil {
+			s.sendError(id, cc, "BAD_REQUEST", "CREATE malformado")
+			return
+		}
+		s.handleCreateCmd(id, cc, msg)
+	case protocol.TypeJoin:
+		var msg protocol.JoinMsg
+		if err := json.Unmarshal(data, &msg); err != nil {
+			s.sendError(id, cc, "BAD_REQUEST", "JOIN malformado")
+			return
+		}
+		s.handleJoinCmd(id, cc, msg)
+	case protocol.TypeLeave:
+		var msg protocol.LeaveMsg
+		if err := json.Unmarshal(data, &msg); err != nil {
+			s.sendError(id, cc, "BAD_REQUEST", "LEAVE malformado")
+			return
+		}
+		s.handleLeaveCmd(id, cc, msg)
+	case protocol.TypeVote:
+		var msg protocol.VoteMsg
+		if err := json.Unmarshal(data, &msg); err != nil {
+			s.sendError(id, cc, "BAD_REQUEST", "VOTE malformado")
+			return
+		}
+		s.handleVoteCmd(id, cc, msg)
+	}
+}
+
+// sendError codifica e envia um ErrorMsg ao cliente.
+func (s *Server) sendError(id string, cc *clientConn, code, message string) {
+	frame, err := protocol.EncodeFrame(protocol.TypeError, protocol.ErrorMsg{Code: code, Message: message})
+	if err != nil {
+		log.Printf("sendError: erro ao codificar erro para %s: %v", id, err)
+		return
+	}
+	s.send(id, cc, frame)
+}
+
+// sendOk codifica e envia um OkMsg ao cliente.
+func (s *Server) sendOk(id string, cc *clientConn, message string) {
+	frame, err := protocol.EncodeFrame(protocol.TypeOk, protocol.OkMsg{Message: message})
+	if err != nil {
+		log.Printf("sendOk: erro ao codificar confirmação para %s: %v", id, err)
+		return
+	}
+	s.send(id, cc, frame)
+}
+
+// handleListCmd responde ao comando LIST com todos os polls e seus estados.
+func (s *Server) handleListCmd(id string, cc *clientConn) {
+	s.pollsMu.RLock()
+	polls := make([]protocol.PollInfo, 0, len(s.polls))
+	for pollID, poll := range s.polls {
+		poll.mu.RLock()
+		polls = append(polls, protocol.PollInfo{ID: pollID, State: string(poll.state)})
+		poll.mu.RUnlock()
+	}
+	s.pollsMu.RUnlock()
+
+	frame, err := protocol.EncodeFrame(protocol.TypePollList, protocol.PollListMsg{Polls: polls})
+	if err != nil {
+		log.Printf("handleListCmd: erro ao codificar lista de polls para %s: %v", id, err)
+		return
+	}
+	s.send(id, cc, frame)
+}
+
+// handleCreateCmd implementa a mensagem CreateMsg.
+func (s *Server) handleCreateCmd(id string, cc *clientConn, msg protocol.CreateMsg) {
+	pollID := strings.TrimSpace(msg.PollID)
+	opts := make([]string, 0, len(msg.Options))
+	for _, o := range msg.Options {
+		if o = strings.TrimSpace(o); o != "" {
+			opts = append(opts, o)
+		}
+	}
+	if pollID == "" || len(opts) == 0 {
+		s.sendError(id, cc, "BAD_REQUEST", "Informe poll_id e ao menos uma opcao")
+		return
+	}
+
+	if err := s.CreatePoll(pollID, opts); err != nil {
+		s.sendError(id, cc, "ALREADY_EXISTS", err.Error())
+		return
+	}
+	s.sendOk(id, cc, fmt.Sprintf("Poll '%s' criado. Opcoes: [%s]", pollID, strings.Join(opts, ", ")))
+}
+
+// handleJoinCmd implementa a mensagem JoinMsg: inscreve o cliente nas
+// atualizações de um poll, pré-requisito para poder votar nele.
+func (s *Server) handleJoinCmd(id string, cc *clientConn, msg protocol.JoinMsg) {
+	pollID := strings.TrimSpace(msg.PollID)
+	poll := s.getPoll(pollID)
+	if poll == nil {
+		s.sendError(id, cc, "NOT_FOUND", fmt.Sprintf("Poll '%s' nao existe", pollID))
+		return
+	}
+
+	poll.mu.Lock()
+	poll.subscribers[id] = struct{}{}
+	status := poll.statusLocked()
+	poll.mu.Unlock()
+
+	cc.trackJoin(pollID)
+	s.sendOk(id, cc, fmt.Sprintf("Entrou no poll '%s'. %s", pollID, status))
+}
+
+// handleLeaveCmd implementa a mensagem LeaveMsg: encerra a inscrição do
+// cliente; ele deixa de receber os UpdateMsg daquele poll.
+func (s *Server) handleLeaveCmd(id string, cc *clientConn, msg protocol.LeaveMsg) {
+	pollID := strings.TrimSpace(msg.PollID)
+	poll := s.getPoll(pollID)
+	if poll == nil {
+		s.sendError(id, cc, "NOT_FOUND", fmt.Sprintf("Poll '%s' nao existe", pollID))
+		return
+	}
+
+	poll.mu.Lock()
+	delete(poll.subscribers, id)
+	poll.mu.Unlock()
+
+	cc.trackLeave(pollID)
+	s.sendOk(id, cc, fmt.Sprintf("Saiu do poll '%s'", pollID))
+}
+
+// handleVoteCmd implementa a mensagem VoteMsg.
+func (s *Server) handleVoteCmd(id string, cc *clientConn, msg protocol.VoteMsg) {
+	pollID := strings.TrimSpace(msg.PollID)
+	poll := s.getPoll(pollID)
+	if poll == nil {
+		s.sendError(id, cc, "NOT_FOUND", fmt.Sprintf("Poll '%s' nao existe", pollID))
+		return
+	}
+
+	s.processVote(id, cc, poll, msg.Option)
+}
+
+// writeConn escreve msg em conn aplicando s.WriteTimeout; um cliente que não
+// drena seu TCP receive buffer faz isto estourar em vez de bloquear para
+// sempre. conn é o stream físico já resolvido pelo chamador (ver
+// clientStreams.resolve) - o mesmo net.Conn para os três papéis em
+// TransportRaw, um stream yamux independente por papel em TransportMux.
+func (s *Server) writeConn(conn net.Conn, msg []byte) error {
+	conn.SetWriteDeadline(time.Now().Add(s.WriteTimeout))
+	_, err := conn.Write(msg)
+	if err != nil {
+		if netErr, ok := err.(net.Error); ok && netErr.Timeout() {
+			s.Metrics.WriteTimeouts.Add(1)
+		}
+		return err
+	}
+	s.Metrics.BroadcastsSent.Add(1)
+	return nil
+}
+
+// ensureQueueLocked retorna a fila de saída de (cliente, queueKey), criando-a
+// e subindo sua goroutine de envio (pollSenderLoop) na primeira chamada.
+// Chamado com cc.queuesMu já travado pelo chamador - ver enqueueOrWrite, que
+// precisa manter essa mesma trava até o próprio enqueue para não reabrir a
+// corrida que closeQueues existe para fechar.
+func (s *Server) ensureQueueLocked(id string, cc *clientConn, queueKey string) chan []byte {
+	if q, ok := cc.queues[queueKey]; ok {
+		return q
+	}
+	q := make(chan []byte, clientSendQueueDepth)
+	cc.queues[queueKey] = q
+	s.wg.Add(1)
+	go s.pollSenderLoop(id, queueKey, cc, q)
+	return q
+}
+
+// pollSenderLoop é a goroutine dedicada de escrita de uma fila de um cliente
+// em MODE_FANOUT (controlQueueKey ou eventQueueKey(pollID); o placar de cada
+// poll usa o outbox de pollOutboxLoop). Aplica um SetWriteDeadline
+// por escrita; erro ou timeout encerra a conexão inteira do cliente, o que
+// libera o handleClient bloqueado em Decode.
+func (s *Server) pollSenderLoop(id, queueKey string, cc *clientConn, q chan []byte) {
+	defer s.wg.Done()
+	conn, mu := cc.streams.resolve(queueKey)
+	for msg := range q {
+		mu.Lock()
+		err := s.writeConn(conn, msg)
+		mu.Unlock()
+		if err != nil {
+			log.Printf("[FANOUT] erro/timeout ao escrever para %s (fila '%s'): %v", id, queueKey, err)
+			cc.close()
+			return
+		}
+	}
+}
+
+// ensureOutbox retorna o outbox de placar de (cliente, pollID), criando-o e
+// subindo sua goroutine de envio (pollOutboxLoop) na primeira chamada. ok é
+// false se cc.outboxes já foi fechado por closeOutboxes - nesse caso nenhum
+// outbox novo é criado, para não subir uma pollOutboxLoop que nunca mais
+// seria fechada (vazando a goroutine para sempre bloqueada em Pop). Um
+// outbox já existente continua seguro de usar depois do fechamento: Push
+// checa clientOutbox.closed sozinho (ver outbox.go).
+func (s *Server) ensureOutbox(id, pollID string, cc *clientConn) (ob *clientOutbox, ok bool) {
+	cc.outboxesMu.Lock()
+	defer cc.outboxesMu.Unlock()
+
+	if ob, exists := cc.outboxes[pollID]; exists {
+		return ob, true
+	}
+	if cc.outboxesClosed {
+		return nil, false
+	}
+	ob = newClientOutbox()
+	cc.outboxes[pollID] = ob
+	s.wg.Add(1)
+	go s.pollOutboxLoop(id, pollID, cc, ob)
+	return ob, true
+}
+
+// pollOutboxLoop é a goroutine dedicada de escrita do outbox de placar de um
+// cliente: espera por um frame via Pop (bloqueante, sem busy-wait) e o
+// escreve fora de qualquer mutex de s ou do poll. Erro ou timeout encerra a
+// conexão inteira do cliente, o que libera o handleClient bloqueado em
+// Decode - o mesmo contrato de pollSenderLoop.
+func (s *Server) pollOutboxLoop(id, pollID string, cc *clientConn, ob *clientOutbox) {
+	defer s.wg.Done()
+	conn, mu := cc.streams.resolve(pollID)
+	for {
+		frame, ok := ob.Pop()
+		if !ok {
+			return
+		}
+		mu.Lock()
+		err := s.writeConn(conn, frame)
+		mu.Unlock()
+		if err != nil {
+			log.Printf("[FANOUT] erro/timeout ao escrever placar para %s (poll '%s'): %v", id, pollID, err)
+			cc.close()
+			return
+		}
+	}
+}
 
-	conn := s.clients[id]  // Guarda referência para enviar respostas
-
-    // VALIDAÇÃO 1: Votação não iniciada
-    if s.votingState == VotingNotStarted {
-        conn.Write([]byte("ERRO: Votacao nao iniciada\n"))
-        log.Printf("Voto rejeitado (%s): votação não iniciada", id)
-        return  // ← defer garante que mutex será liberado
-    }
-
-    // VALIDAÇÃO 2: Votação já encerrada
-    if s.votingState == VotingEnded {
-        conn.Write([]byte("ERRO: Votacao encerrada\n"))
-        log.Printf("Voto rejeitado (%s): votação encerrada", id)
-        return
-    }
-
-    // VALIDAÇÃO 3: Tempo limite expirado
-    if time.Now().After(s.votingDeadline) {
-        conn.Write([]byte("ERRO: Tempo limite expirado\n"))
-        log.Printf("Voto rejeitado (%s): tempo expirado", id)
-        // Encerra votação (este método já tem seu próprio Lock/Unlock)
-        go s.endVoting()  // ← async para evitar deadlock
-        return
-    }
-
-    // VALIDAÇÃO 4: Voto duplicado
-    if _, jaVotou := s.votes[id]; jaVotou {
-        conn.Write([]byte("ERRO: Voto duplicado\n"))
-        log.Printf("Voto rejeitado (%s): já votou", id)
-        return
-    }
+// enqueueOrWrite entrega msg a um cliente na fila queueKey. Em ModeSync e
+// ModeAsync escreve direto com prazo (comportamento histórico, usado para
+// comparação didática). Em ModeFanout nunca toca a rede: apenas enfileira,
+// de forma não-bloqueante, e deixa pollSenderLoop fazer o I/O.
+func (s *Server) enqueueOrWrite(id string, cc *clientConn, queueKey string, msg []byte) {
+	if s.mode != ModeFanout {
+		conn, mu := cc.streams.resolve(queueKey)
+		mu.Lock()
+		err := s.writeConn(conn, msg)
+		mu.Unlock()
+		if err != nil {
+			log.Printf("[%s] erro/timeout ao escrever para %s: %v", s.mode, id, err)
+			cc.close()
+		}
+		return
+	}
+
+	// A criação/busca da fila e o enqueue em si ficam sob o mesmo queuesMu
+	// que closeQueues usa para marcar cc.queuesClosed e fechar as filas -
+	// sem isso, um StartVoting/endVoting atrasado poderia pegar uma fila
+	// instantes antes dela ser fechada e sofrer panic ("send on closed
+	// channel") ao tentar enfileirar.
+	cc.queuesMu.Lock()
+	if cc.queuesClosed {
+		cc.queuesMu.Unlock()
+		return
+	}
+	q := s.ensureQueueLocked(id, cc, queueKey)
+	select {
+	case q <- msg:
+		cc.queuesMu.Unlock()
+	default:
+		cc.queuesMu.Unlock()
+		d := atomic.AddInt64(&cc.dropped, 1)
+		s.Metrics.BroadcastsDroppedSlowClient.Add(1)
+		log.Printf("[FANOUT] fila '%s' cheia para %s, descartando frame (total descartado: %d)", queueKey, id, d)
+		if d >= maxQueueDrops {
+			log.Printf("[FANOUT] %s excedeu %d descartes, encerrando conexão", id, maxQueueDrops)
+			cc.close()
+		}
+	}
+}
+
+// send entrega uma resposta direta (não ligada a um poll específico) a um
+// cliente: boas-vindas, confirmações, erros.
+func (s *Server) send(id string, cc *clientConn, msg []byte) {
+	s.enqueueOrWrite(id, cc, controlQueueKey, msg)
+}
+
+// sendToPoll entrega um UpdateMsg (placar) de um poll a este cliente. Em
+// ModeSync/ModeAsync escreve direto com prazo, como enqueueOrWrite. Em
+// ModeFanout usa o outbox coalescente de outbox.go em vez da fila genérica
+// de enqueueOrWrite: um UpdateMsg só carrega o placar mais atual, então
+// descartar uma atualização intermediária de um cliente lento não perde
+// informação nenhuma - a fila genérica, por comparação, trataria cada
+// UpdateMsg como um frame opaco e descartaria o mais recente ao encher.
+func (s *Server) sendToPoll(id string, cc *clientConn, pollID string, msg []byte) {
+	if s.mode != ModeFanout {
+		conn, mu := cc.streams.resolve(pollID)
+		mu.Lock()
+		err := s.writeConn(conn, msg)
+		mu.Unlock()
+		if err != nil {
+			log.Printf("[%s] erro/timeout ao escrever placar para %s: %v", s.mode, id, err)
+			cc.close()
+		}
+		return
+	}
+
+	ob, ok := s.ensureOutbox(id, pollID, cc)
+	if !ok {
+		return // cc.outboxes já fechado (cliente desconectando/Shutdown)
+	}
+	dropped, slowFor := ob.Push(msg)
+	if dropped {
+		s.Metrics.OutboxFramesDropped.Add(1)
+		log.Printf("[FANOUT] outbox de placar cheio para %s (poll '%s'), descartando atualizacao intermediaria", id, pollID)
+	}
+	if s.SlowClientDeadline > 0 && slowFor > s.SlowClientDeadline {
+		log.Printf("[FANOUT] %s excedeu SlowClientDeadline (%s) com outbox de placar cheio, encerrando conexão", id, s.SlowClientDeadline)
+		cc.close()
+	}
+}
+
+// sendEvent entrega um PollEventMsg (início/fim de votação) de um poll na
+// fila de eventos daquele poll para este cliente, roteada - em
+// TransportMux - para o stream de eventos, separado do placar.
+func (s *Server) sendEvent(id string, cc *clientConn, pollID string, msg []byte) {
+	s.enqueueOrWrite(id, cc, eventQueueKey(pollID), msg)
+}
+
+// processVote processa um voto em um poll específico e dispara seu broadcast.
+func (s *Server) processVote(id string, cc *clientConn, poll *Poll, option string) {
+	if s.Cluster != nil {
+		s.processVoteClustered(id, cc, poll, option)
+		return
+	}
+
+	if s.CertRevocation != nil && cc.peerCert != nil {
+		if err := s.CertRevocation(cc.peerCert); err != nil {
+			s.sendError(id, cc, "CERT_REVOKED", "certificado revogado")
+			s.Metrics.VotesRejectedInvalid.Add(1)
+			log.Printf("Voto rejeitado (%s/%s): certificado revogado: %v", poll.ID, id, err)
+			return
+		}
+	}
+
+	poll.mu.Lock()
+
+	// PRÉ-REQUISITO: é preciso ter entrado no poll (JOIN) para votar nele.
+	if _, joined := poll.subscribers[id]; !joined {
+		poll.mu.Unlock()
+		s.sendError(id, cc, "NOT_JOINED", fmt.Sprintf("Entre no poll '%s' primeiro (JOIN)", poll.ID))
+		return
+	}
+
+	// VALIDAÇÃO 1: Votação não iniciada
+	if poll.state == VotingNotStarted {
+		poll.mu.Unlock()
+		s.sendError(id, cc, "NOT_STARTED", "Votacao nao iniciada")
+		s.Metrics.VotesRejectedInvalid.Add(1)
+		log.Printf("Voto rejeitado (%s/%s): votação não iniciada", poll.ID, id)
+		return
+	}
+
+	// VALIDAÇÃO 2: Votação já encerrada
+	if poll.state == VotingEnded {
+		poll.mu.Unlock()
+		s.sendError(id, cc, "ENDED", "Votacao encerrada")
+		s.Metrics.VotesRejectedInvalid.Add(1)
+		log.Printf("Voto rejeitado (%s/%s): votação encerrada", poll.ID, id)
+		return
+	}
+
+	// VALIDAÇÃO 3: Tempo limite expirado
+	if time.Now().After(poll.deadline) {
+		poll.mu.Unlock()
+		s.sendError(id, cc, "EXPIRED", "Tempo limite expirado")
+		s.Metrics.VotesRejectedInvalid.Add(1)
+		log.Printf("Voto rejeitado (%s/%s): tempo expirado", poll.ID, id)
+		go s.endVoting(poll.ID) // async para evitar deadlock com poll.mu
+		return
+	}
+
+	// VALIDAÇÃO 4: Voto duplicado
+	if _, jaVotou := poll.votes[id]; jaVotou {
+		poll.mu.Unlock()
+		s.sendError(id, cc, "DUPLICATE", "Voto duplicado")
+		s.Metrics.VotesRejectedDuplicate.Add(1)
+		log.Printf("Voto rejeitado (%s/%s): já votou", poll.ID, id)
+		return
+	}
 
 	// VALIDAÇÃO 5: Opção inválida
-    isValid := false
-    for _, validOption := range s.options.List {
-        if option == validOption {
-            isValid = true
-            break
-        }
-    }
-
-    if !isValid {
-        conn.Write([]byte(fmt.Sprintf("ERRO: Opcao invalida. Use: [%s]\n", s.options.DisplayString)))
-        log.Printf("Voto rejeitado (%s): opção inválida '%s'", id, option)
-        return
-    }
-
-    // VOTO VÁLIDO - Registra
-    s.votes[id] = option
-    s.voteCounts[option]++
-
-    // CONFIRMAÇÃO para o cliente
-    confirmation := fmt.Sprintf("OK: Voto registrado -> %s\n", option)
-    conn.Write([]byte(confirmation))
-    log.Printf("Voto aceito: %s -> %s", id, option)
-
-	if s.useAsyncBroadcast {
-		// MODO ASSÍNCRONO: Evita I/O bloqueante com mutex travado
-		
-		// Snapshot do placar (cópia profunda evita race conditions)
-		snapshot := make(map[string]int, len(s.voteCounts))
-		for k, v := range s.voteCounts {
-			snapshot[k] = v
-		}
-
-		// Envia para channel (operação rápida, não bloqueia se buffer não está cheio)
-		// Worker goroutine fará o I/O de rede fora da seção crítica
-		s.broadcastChan <- snapshot
-	} else {
-		// MODO BLOQUEANTE: I/O de rede com mutex travado
-		// PROBLEMA: Se conn.Write() bloquear (cliente lento), 
-		// toda votação trava (mutex não é liberado)
-		s.broadcastLocked()
+	isValid := false
+	for _, validOption := range poll.Options.List {
+		if option == validOption {
+			isValid = true
+			break
+		}
+	}
+
+	if !isValid {
+		poll.mu.Unlock()
+		s.sendError(id, cc, "INVALID_OPTION", fmt.Sprintf("Opcao invalida. Use: [%s]", poll.Options.DisplayString))
+		s.Metrics.VotesRejectedInvalid.Add(1)
+		log.Printf("Voto rejeitado (%s/%s): opção inválida '%s'", poll.ID, id, option)
+		return
+	}
+
+	// VOTO VÁLIDO - Registra
+	poll.votes[id] = option
+	poll.voteCounts[option]++
+	poll.seq++
+	seq := poll.seq
+	s.Metrics.VotesAccepted.Add(1)
+
+	confirmation := fmt.Sprintf("Voto registrado em '%s' -> %s", poll.ID, option)
+
+	switch s.mode {
+	case ModeFanout:
+		// MODE_FANOUT: enfileira para cada inscrito sem segurar poll.mu
+		// durante I/O - quem escreve de fato é a goroutine pollOutboxLoop.
+		frame, err := protocol.EncodeFrame(protocol.TypeUpdate, protocol.UpdateMsg{
+			PollID: poll.ID, Counts: cloneCounts(poll.voteCounts), Seq: seq,
+		})
+		subs := make([]string, 0, len(poll.subscribers))
+		for subID := range poll.subscribers {
+			subs = append(subs, subID)
+		}
+		poll.mu.Unlock()
+
+		s.sendOk(id, cc, confirmation)
+		log.Printf("Voto aceito (%s): %s -> %s", poll.ID, id, option)
+		if err != nil {
+			log.Printf("processVote: erro ao codificar UpdateMsg do poll '%s': %v", poll.ID, err)
+			return
+		}
+		for _, subID := range subs {
+			if subCC := s.getClient(subID); subCC != nil {
+				s.sendToPoll(subID, subCC, poll.ID, frame)
+			}
+		}
+	case ModeAsync:
+		// MODO ASSÍNCRONO: copia o placar e delega o I/O ao worker, fora
+		// da seção crítica do poll.
+		snapshot := cloneCounts(poll.voteCounts)
+		poll.mu.Unlock()
+
+		s.sendOk(id, cc, confirmation)
+		log.Printf("Voto aceito (%s): %s -> %s", poll.ID, id, option)
+		s.broadcastChan <- pollUpdate{pollID: poll.ID, counts: snapshot, seq: seq}
+	default:
+		// MODO BLOQUEANTE: I/O de rede com poll.mu travado.
+		// PROBLEMA: se conn.Write() bloquear (cliente lento), todo o poll
+		// trava - mas só este poll, nunca os demais.
+		s.sendOk(id, cc, confirmation)
+		log.Printf("Voto aceito (%s): %s -> %s", poll.ID, id, option)
+		s.broadcastPollLocked(poll, seq)
+		poll.mu.Unlock()
 	}
 }
 
-// broadcastLocked envia atualizações segurando o mutex principal (modo bloqueante).
-func (s *Server) broadcastLocked() {
-	log.Println("[SYNC] Iniciando broadcast síncrono (MUTEX LOCK)")
-	padding := strings.Repeat("\x00", 256*1024) // 256KB
-    msg := fmt.Sprintf("UPDATE: %v | SNAPSHOT: %s\n", s.voteCounts, padding)
+// processVoteClustered é o caminho de processVote quando s.Cluster não é
+// nil. O JOIN e a validade da opção continuam sendo checados localmente
+// (são estado da conexão e do poll, não precisam de consenso); votos,
+// duplicidade, fase da votação e prazo são resolvidos de forma autoritativa
+// pela FSM replicada - ver cluster.FSM.Apply. O broadcast do placar não
+// acontece aqui: é disparado por onClusterApply assim que o voto replica,
+// o que também cobre os seguidores que nunca chamaram Cluster.Apply.
+func (s *Server) processVoteClustered(id string, cc *clientConn, poll *Poll, option string) {
+	if s.CertRevocation != nil && cc.peerCert != nil {
+		if err := s.CertRevocation(cc.peerCert); err != nil {
+			s.sendError(id, cc, "CERT_REVOKED", "certificado revogado")
+			s.Metrics.VotesRejectedInvalid.Add(1)
+			log.Printf("Voto rejeitado (%s/%s): certificado revogado: %v", poll.ID, id, err)
+			return
+		}
+	}
+
+	poll.mu.RLock()
+	_, joined := poll.subscribers[id]
+	validOptions := poll.Options
+	poll.mu.RUnlock()
+
+	if !joined {
+		s.sendError(id, cc, "NOT_JOINED", fmt.Sprintf("Entre no poll '%s' primeiro (JOIN)", poll.ID))
+		return
+	}
 
-	for id, conn := range s.clients {
-		if _, votou := s.votes[id]; votou {
-			// GARGALO: write() pode bloquear se TCP send buffer estiver cheio
-			// (cliente não lê dados, sliding window = 0)
-			// Mutex permanece travado durante bloqueio = servidor congelado
-			log.Printf("[SYNC] Tentando enviar para %s...", id)
-            n, err := conn.Write([]byte(msg))
-            
-            if err != nil {
-                log.Printf("[SYNC] ERRO ao enviar para %s: %v", id, err)
-            } else if n < len(msg) {
-                log.Printf("[SYNC] PARCIAL para %s: enviados %d/%d bytes", 
-                    id, n, len(msg))
-            } else {
-                log.Printf("[SYNC] Sucesso para %s: %d bytes", id, n)
-            }
+	isValid := false
+	for _, o := range validOptions.List {
+		if option == o {
+			isValid = true
+			break
 		}
 	}
-	log.Println("[SYNC] Fim do broadcast síncrono")
+	if !isValid {
+		s.sendError(id, cc, "INVALID_OPTION", fmt.Sprintf("Opcao invalida. Use: [%s]", validOptions.DisplayString))
+		s.Metrics.VotesRejectedInvalid.Add(1)
+		return
+	}
+
+	_, err := s.Cluster.Apply(cluster.Command{Type: cluster.CmdVote, PollID: poll.ID, VoterID: id, Option: option})
+	if err != nil {
+		if errors.Is(err, cluster.ErrNotLeader) {
+			s.sendError(id, cc, "NAO_LIDER", fmt.Sprintf("lider atual: %s", s.Cluster.LeaderAddr()))
+			return
+		}
+		// Rejeição de nível de aplicação (poll não iniciado/encerrado,
+		// prazo expirado, voto duplicado) - a FSM já validou com mais
+		// detalhe do que vale a pena reexpor em códigos de erro distintos.
+		s.sendError(id, cc, "REJECTED", err.Error())
+		s.Metrics.VotesRejectedInvalid.Add(1)
+		return
+	}
+
+	s.Metrics.VotesAccepted.Add(1)
+	s.sendOk(id, cc, fmt.Sprintf("Voto registrado em '%s' -> %s", poll.ID, option))
+	log.Printf("Voto aceito (cluster, %s): %s -> %s", poll.ID, id, option)
 }
 
-// broadcastWorker consome channel e faz broadcast assíncrono.
+// onClusterApply é registrada em cluster.FSM.OnApply (ver NewClusteredServer)
+// e chamada de forma síncrona sempre que um Command replica - em qualquer
+// nó, líder ou seguidor. Sincroniza o espelho local do Poll (usado pelas
+// leituras rápidas de LIST/JOIN) e dispara, para os inscritos conectados a
+// ESTE nó, o mesmo UpdateMsg/PollEventMsg que processVote/StartVoting/
+// endVoting disparariam num servidor de nó único.
+func (s *Server) onClusterApply(result cluster.ApplyResult) {
+	if result.Err != nil {
+		return
+	}
+	poll := s.getPoll(result.Cmd.PollID)
+	if poll == nil {
+		return
+	}
+
+	poll.mu.Lock()
+	poll.voteCounts = result.VoteCounts
+	poll.state = VotingState(result.State)
+	poll.seq = result.Seq
+	subs := make([]string, 0, len(poll.subscribers))
+	for subID := range poll.subscribers {
+		subs = append(subs, subID)
+	}
+	displayOptions := poll.Options.DisplayString
+	poll.mu.Unlock()
+
+	switch result.Cmd.Type {
+	case cluster.CmdVote:
+		frame, err := protocol.EncodeFrame(protocol.TypeUpdate, protocol.UpdateMsg{
+			PollID: result.Cmd.PollID, Counts: cloneCounts(result.VoteCounts), Seq: result.Seq,
+		})
+		if err != nil {
+			log.Printf("onClusterApply: erro ao codificar UpdateMsg do poll '%s': %v", result.Cmd.PollID, err)
+			return
+		}
+		for _, subID := range subs {
+			if subCC := s.getClient(subID); subCC != nil {
+				s.sendToPoll(subID, subCC, result.Cmd.PollID, frame)
+			}
+		}
+	case cluster.CmdStartVoting:
+		frame, err := protocol.EncodeFrame(protocol.TypePollEvent, protocol.PollEventMsg{
+			PollID: result.Cmd.PollID, Event: "STARTED",
+			Message: fmt.Sprintf("Votacao iniciada: %d segundos. Opcoes: [%s]", result.Cmd.Duration, displayOptions),
+		})
+		if err != nil {
+			log.Printf("onClusterApply: erro ao codificar PollEventMsg (STARTED) do poll '%s': %v", result.Cmd.PollID, err)
+			return
+		}
+		for _, subID := range subs {
+			if subCC := s.getClient(subID); subCC != nil {
+				s.sendEvent(subID, subCC, result.Cmd.PollID, frame)
+			}
+		}
+		time.AfterFunc(time.Duration(result.Cmd.Duration)*time.Second, func() {
+			s.endVoting(result.Cmd.PollID)
+		})
+	case cluster.CmdEndVoting:
+		frame, err := protocol.EncodeFrame(protocol.TypePollEvent, protocol.PollEventMsg{
+			PollID: result.Cmd.PollID, Event: "ENDED",
+			Message: fmt.Sprintf("Votacao encerrada. Resultado: %v", result.VoteCounts),
+		})
+		if err != nil {
+			log.Printf("onClusterApply: erro ao codificar PollEventMsg (ENDED) do poll '%s': %v", result.Cmd.PollID, err)
+			return
+		}
+		for _, subID := range subs {
+			if subCC := s.getClient(subID); subCC != nil {
+				s.sendEvent(subID, subCC, result.Cmd.PollID, frame)
+			}
+		}
+	}
+}
+
+// broadcastPollLocked envia o placar do poll segurando poll.mu (ModeSync).
+// Assume poll.mu já travado (escrita) pelo chamador.
+func (s *Server) broadcastPollLocked(poll *Poll, seq uint64) {
+	log.Printf("[SYNC] Iniciando broadcast síncrono do poll '%s' (MUTEX LOCK)", poll.ID)
+	// Padding infla o frame para encher o TCP send buffer, tornando o
+	// bloqueio de poll.mu observável no demo didático deste modo. Usa "A" em
+	// vez de "\x00": o envelope do frame é JSON, e json.Marshal escapa cada
+	// byte nulo como seis bytes, inflando 256KB de zeros para
+	// ~1.5MiB e estourando MaxFrameSize em todo broadcast - "A" não exige
+	// escape, então o frame final fica perto dos 256KB pretendidos.
+	frame, err := protocol.EncodeFrame(protocol.TypeUpdate, protocol.UpdateMsg{
+		PollID:  poll.ID,
+		Counts:  cloneCounts(poll.voteCounts),
+		Seq:     seq,
+		Padding: strings.Repeat("A", 256*1024), // 256KB
+	})
+	if err != nil {
+		log.Printf("[SYNC] erro ao codificar UpdateMsg do poll '%s': %v", poll.ID, err)
+		return
+	}
+
+	for subID := range poll.subscribers {
+		cc := s.getClient(subID)
+		if cc == nil {
+			continue
+		}
+		// GARGALO: write() pode bloquear se TCP send buffer estiver cheio
+		// (cliente não lê dados, sliding window = 0)
+		// poll.mu permanece travado durante bloqueio = poll congelado
+		log.Printf("[SYNC] Tentando enviar para %s...", subID)
+		conn, mu := cc.streams.resolve(poll.ID)
+		mu.Lock()
+		err := s.writeConn(conn, frame)
+		mu.Unlock()
+		if err != nil {
+			log.Printf("[SYNC] ERRO/timeout ao enviar para %s: %v", subID, err)
+			cc.close()
+		} else {
+			log.Printf("[SYNC] Sucesso para %s", subID)
+		}
+	}
+	log.Printf("[SYNC] Fim do broadcast síncrono do poll '%s'", poll.ID)
+}
+
+// broadcastWorker consome broadcastChan e faz broadcast assíncrono (ModeAsync).
 func (s *Server) broadcastWorker() {
+	defer s.wg.Done()
 	// Consome canal em loop infinito
 	// Bloqueia (sem consumir CPU) quando canal vazio
 	for update := range s.broadcastChan {
-		log.Println("[ASYNC] Iniciando broadcast assíncrono")
-
-		// DESCOMENTE para simular broadcast com mensagem gigante (256KB)
-        // Útil para demonstrar que modo async não trava mesmo com cliente lento
-
-        // padding := strings.Repeat("\x00", 256*1024) // 256KB
-        // msg = fmt.Sprintf("UPDATE: %v | SNAPSHOT: %s\n", update, padding)
-        // log.Printf("[ASYNC] Modo LARGE PAYLOAD")
-
-		// COMENTAR ESSA LINHA PARA FAZER A SIMULACAO
-		msg := fmt.Sprintf("UPDATE: %v\n", update)
+		log.Printf("[ASYNC] Iniciando broadcast assíncrono do poll '%s'", update.pollID)
+		frame, err := protocol.EncodeFrame(protocol.TypeUpdate, protocol.UpdateMsg{
+			PollID: update.pollID, Counts: update.counts, Seq: update.seq,
+		})
+		if err != nil {
+			log.Printf("[ASYNC] erro ao codificar UpdateMsg do poll '%s': %v", update.pollID, err)
+			continue
+		}
 
-		msgBytes := []byte(msg)
+		poll := s.getPoll(update.pollID)
+		if poll == nil {
+			continue
+		}
+		poll.mu.RLock()
+		subs := make([]string, 0, len(poll.subscribers))
+		for subID := range poll.subscribers {
+			subs = append(subs, subID)
+		}
+		poll.mu.RUnlock()
 
-		// Mutex travado apenas durante leitura do mapa de clientes
-		s.mu.Lock()
-		
-		// Snapshot de clientes para envio fora da seção crítica
-		// (solução ideal seria copiar clientes também, mas didaticamente aceitável)
-		for id, conn := range s.clients {
-			if _, votou := s.votes[id]; votou {
-				// SYSCALL: write(fd, buffer, len)
-				// Pode bloquear aqui, mas não trava votações
-				// (goroutines de voto já liberaram mutex)
-				conn.Write(msgBytes)
+		for _, subID := range subs {
+			cc := s.getClient(subID)
+			if cc == nil {
+				continue
+			}
+			// SYSCALL: write(fd, buffer, len)
+			// Pode bloquear até s.WriteTimeout, mas não trava votações
+			// (goroutines de voto já liberaram poll.mu)
+			conn, mu := cc.streams.resolve(update.pollID)
+			mu.Lock()
+			err := s.writeConn(conn, frame)
+			mu.Unlock()
+			if err != nil {
+				log.Printf("[ASYNC] ERRO/timeout ao enviar para %s: %v", subID, err)
+				cc.close()
 			}
 		}
-		
-		s.mu.Unlock()
 
-		log.Println("[ASYNC] Fim do broadcast assíncrono")
+		log.Printf("[ASYNC] Fim do broadcast assíncrono do poll '%s'", update.pollID)
 	}
 }
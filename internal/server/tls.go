@@ -0,0 +1,36 @@
+package server
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"os"
+)
+
+// LoadMutualTLS monta um *tls.Config para o servidor exigir e validar o
+// certificado de cliente em cada conexão (mTLS): certFile/keyFile são o par
+// do servidor, usado para autenticar a si mesmo perante o cliente; caFile é
+// a CA que assina os certificados de cliente aceitos. Cada cliente autenticado
+// tem sua identidade extraída do CommonName do certificado (ver handleClient),
+// nunca do HELLO enviado pelo próprio cliente.
+func LoadMutualTLS(caFile, certFile, keyFile string) (*tls.Config, error) {
+	cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+	if err != nil {
+		return nil, fmt.Errorf("server: erro ao carregar certificado do servidor: %w", err)
+	}
+
+	caPEM, err := os.ReadFile(caFile)
+	if err != nil {
+		return nil, fmt.Errorf("server: erro ao ler CA de clientes %s: %w", caFile, err)
+	}
+	caPool := x509.NewCertPool()
+	if !caPool.AppendCertsFromPEM(caPEM) {
+		return nil, fmt.Errorf("server: CA de clientes %s nao contem nenhum certificado PEM valido", caFile)
+	}
+
+	return &tls.Config{
+		Certificates: []tls.Certificate{cert},
+		ClientCAs:    caPool,
+		ClientAuth:   tls.RequireAndVerifyClientCert,
+	}, nil
+}
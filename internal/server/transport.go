@@ -0,0 +1,136 @@
+package server
+
+import (
+	"fmt"
+	"net"
+	"strings"
+	"sync"
+
+	"github.com/hashicorp/yamux"
+)
+
+// TransportMode seleciona como o servidor transporta os fluxos lógicos do
+// protocolo (controle/comandos, placar de votos, eventos de ciclo de vida)
+// sobre a conexão TCP de cada cliente.
+type TransportMode int
+
+const (
+	// TransportRaw usa um único pipe de bytes para tudo: controle, placar e
+	// eventos competem pelo mesmo socket e pelo mesmo buffer de escrita do
+	// kernel. Um cliente lento para de ler e write() trava para os três
+	// fluxos ao mesmo tempo, como demonstra o blockedClient de
+	// test/loadtest.go.
+	TransportRaw TransportMode = iota
+	// TransportMux abre uma sessão yamux sobre a conexão TCP aceita e usa
+	// três streams lógicos (controle, placar, eventos), cada um com sua
+	// própria janela de controle de fluxo. Um placar que enche sua janela
+	// não impede mais os comandos do cliente (JOIN, VOTE, LIST) de fluir no
+	// stream de controle.
+	TransportMux
+)
+
+func (t TransportMode) String() string {
+	switch t {
+	case TransportRaw:
+		return "RAW"
+	case TransportMux:
+		return "MUX"
+	default:
+		return "DESCONHECIDO"
+	}
+}
+
+// eventQueueKeyPrefix identifica as filas de saída usadas para PollEventMsg
+// (início/fim de votação) e para o aviso de SHUTDOWN, distintas das filas de
+// UpdateMsg (placar) do mesmo poll mesmo quando ambas recaem sobre o mesmo
+// stream físico (TransportRaw).
+const eventQueueKeyPrefix = "_event:"
+
+// eventQueueKey identifica a fila de eventos de ciclo de vida de um poll.
+func eventQueueKey(pollID string) string {
+	return eventQueueKeyPrefix + pollID
+}
+
+// clientStreams agrega os três fluxos lógicos do protocolo de um cliente:
+// controle (HELLO/comandos/respostas diretas), placar (UpdateMsg) e eventos
+// (PollEventMsg, ShutdownMsg). Em TransportRaw os três apontam para o mesmo
+// net.Conn e compartilham um único mutex de escrita, preservando a semântica
+// de socket único; em TransportMux cada um é um stream yamux independente
+// com seu próprio mutex, já que streams distintos podem ser escritos em
+// paralelo sem serialização entre si.
+type clientStreams struct {
+	control   net.Conn
+	broadcast net.Conn
+	event     net.Conn
+
+	controlMu   *sync.Mutex
+	broadcastMu *sync.Mutex
+	eventMu     *sync.Mutex
+
+	session *yamux.Session // nil em TransportRaw
+}
+
+// openServerStreams estabelece os fluxos lógicos de um cliente recém aceito
+// de acordo com transport. Em TransportMux, o cliente deve abrir os três
+// streams nesta mesma ordem - controle, depois placar, depois eventos - ao
+// discar sua sessão yamux; ver cmd/client/main.go.
+func openServerStreams(conn net.Conn, transport TransportMode) (*clientStreams, error) {
+	if transport != TransportMux {
+		mu := &sync.Mutex{}
+		return &clientStreams{
+			control: conn, broadcast: conn, event: conn,
+			controlMu: mu, broadcastMu: mu, eventMu: mu,
+		}, nil
+	}
+
+	session, err := yamux.Server(conn, nil)
+	if err != nil {
+		return nil, fmt.Errorf("transport: erro ao abrir sessao yamux: %w", err)
+	}
+
+	control, err := session.Accept()
+	if err != nil {
+		session.Close()
+		return nil, fmt.Errorf("transport: erro ao aceitar stream de controle: %w", err)
+	}
+	broadcast, err := session.Accept()
+	if err != nil {
+		session.Close()
+		return nil, fmt.Errorf("transport: erro ao aceitar stream de placar: %w", err)
+	}
+	event, err := session.Accept()
+	if err != nil {
+		session.Close()
+		return nil, fmt.Errorf("transport: erro ao aceitar stream de eventos: %w", err)
+	}
+
+	return &clientStreams{
+		control: control, broadcast: broadcast, event: event,
+		controlMu: &sync.Mutex{}, broadcastMu: &sync.Mutex{}, eventMu: &sync.Mutex{},
+		session: session,
+	}, nil
+}
+
+// resolve mapeia uma queueKey (controlQueueKey, um pollID ou
+// eventQueueKey(pollID)) no stream físico e no mutex que a serializam.
+func (cs *clientStreams) resolve(queueKey string) (net.Conn, *sync.Mutex) {
+	switch {
+	case queueKey == controlQueueKey:
+		return cs.control, cs.controlMu
+	case strings.HasPrefix(queueKey, eventQueueKeyPrefix):
+		return cs.event, cs.eventMu
+	default:
+		return cs.broadcast, cs.broadcastMu
+	}
+}
+
+// close encerra os três streams e, em TransportMux, a sessão yamux
+// subjacente (que por sua vez fecha a conexão TCP).
+func (cs *clientStreams) close() {
+	cs.control.Close()
+	if cs.session != nil {
+		cs.broadcast.Close()
+		cs.event.Close()
+		cs.session.Close()
+	}
+}
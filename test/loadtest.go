@@ -1,62 +1,99 @@
 package main
 
 import (
-	"bufio"
 	"fmt"
 	"net"
 	"sync"
 	"time"
+
+	"github.com/hashicorp/yamux"
+	"github.com/yLukas077/tcp-vote/internal/protocol"
 )
 
+// openStreams disca o servidor (que fala TransportMux, ver cmd/server/main.go)
+// e abre seus três streams lógicos na ordem esperada por
+// Server.openServerStreams: controle, placar, eventos.
+func openStreams() (control, broadcast, event net.Conn, err error) {
+	conn, err := net.Dial("tcp", "localhost:9000")
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	session, err := yamux.Client(conn, nil)
+	if err != nil {
+		conn.Close()
+		return nil, nil, nil, err
+	}
+	if control, err = session.Open(); err != nil {
+		session.Close()
+		return nil, nil, nil, err
+	}
+	if broadcast, err = session.Open(); err != nil {
+		session.Close()
+		return nil, nil, nil, err
+	}
+	if event, err = session.Open(); err != nil {
+		session.Close()
+		return nil, nil, nil, err
+	}
+	return control, broadcast, event, nil
+}
+
 // fastClient simula cliente que lê dados rapidamente (bom comportamento).
 func fastClient(id int, wg *sync.WaitGroup) {
 	defer wg.Done()
 
-	conn, err := net.Dial("tcp", "localhost:9000")
+	control, broadcast, _, err := openStreams()
 	if err != nil {
 		fmt.Printf("Erro Fast %d: %v\n", id, err)
 		return
 	}
-	defer conn.Close()
+	defer control.Close()
+
+	enc := protocol.NewEncoder(control)
+	dec := protocol.NewDecoder(control)
 
 	// Handshake
-	fmt.Fprintf(conn, "FAST_%d\n", id)
-	bufio.NewReader(conn).ReadString('\n')
+	enc.Encode(protocol.TypeHello, protocol.HelloMsg{ID: fmt.Sprintf("FAST_%d", id)})
+	dec.Decode() // WelcomeMsg
 
-	// Vota para gerar broadcasts
-	fmt.Fprintf(conn, "VOTE A\n")
+	// Entra no poll padrão e vota para gerar broadcasts
+	enc.Encode(protocol.TypeJoin, protocol.JoinMsg{PollID: "default"})
+	enc.Encode(protocol.TypeVote, protocol.VoteMsg{PollID: "default", Option: "A"})
 
-	// Loop de leitura rápida mantém TCP receive buffer vazio
-	reader := bufio.NewReader(conn)
+	// Loop de leitura rápida do placar mantém a janela yamux do stream aberta
+	bdec := protocol.NewDecoder(broadcast)
 	for {
-		_, err := reader.ReadString('\n')
-		if err != nil {
+		if _, _, err := bdec.Decode(); err != nil {
 			return
 		}
 	}
 }
 
-// slowClient simula cliente malicioso que nunca lê dados (ataque DoS).
-// TCP receive buffer enche -> sliding window = 0 -> servidor bloqueia em write()
+// blockedClient simula cliente malicioso que nunca lê o placar (ataque DoS).
+// A janela yamux do stream de placar enche -> servidor bloqueia só nesse
+// stream; o stream de controle dos outros clientes segue livre.
 func blockedClient(wg *sync.WaitGroup) {
 	defer wg.Done()
 
-	conn, err := net.Dial("tcp", "localhost:9000")
+	control, _, _, err := openStreams()
 	if err != nil {
 		fmt.Printf("Erro Slow: %v\n", err)
 		return
 	}
-	defer conn.Close()
+	defer control.Close()
+
+	enc := protocol.NewEncoder(control)
+	dec := protocol.NewDecoder(control)
 
-	fmt.Fprintf(conn, "BLOCKED_CLIENT\n")
-	// Lê mensagem de boas-vindas
-	bufio.NewReader(conn).ReadString('\n')
+	enc.Encode(protocol.TypeHello, protocol.HelloMsg{ID: "BLOCKED_CLIENT"})
+	dec.Decode() // WelcomeMsg
 
 	// Vota para entrar na lista de broadcast
-	fmt.Fprintf(conn, "VOTE A\n")
-	fmt.Println(">>> Cliente parou de ler -> buffer TCP vai encher <<<")
+	enc.Encode(protocol.TypeJoin, protocol.JoinMsg{PollID: "default"})
+	enc.Encode(protocol.TypeVote, protocol.VoteMsg{PollID: "default", Option: "A"})
+	fmt.Println(">>> Cliente parou de ler o placar -> janela do stream vai encher <<<")
 
-	// Nunca lê do socket -> buffer enche -> trava write()
+	// Nunca lê o stream de placar -> janela enche -> trava write() só nele
 	time.Sleep(999 * time.Hour)
 }
 